@@ -0,0 +1,122 @@
+package bf2confluence
+
+import (
+	"strings"
+	"unicode"
+)
+
+// CodeInfo is the parsed info string of a fenced code block, e.g.
+//
+//	```python title="deploy.py" linenumbers=true firstline=10
+type CodeInfo struct {
+	Language   string
+	Attributes map[string]string
+}
+
+// codeParameters are attributes passed to the code macro, in order.
+var codeParameters = []string{"title", "linenumbers", "firstline", "collapse", "theme"}
+
+// languageAliases maps common names of languages to those the code macro
+// knows.
+var languageAliases = map[string]string{
+	"sh":            "bash",
+	"shell":         "bash",
+	"zsh":           "bash",
+	"console":       "bash",
+	"shell-session": "bash",
+	"golang":        "go",
+	"yml":           "yaml",
+	"js":            "javascript",
+	"node":          "javascript",
+	"ts":            "typescript",
+	"py":            "python",
+	"python3":       "python",
+	"rb":            "ruby",
+	"cs":            "c#",
+	"csharp":        "c#",
+	"c++":           "cpp",
+	"cc":            "cpp",
+	"cxx":           "cpp",
+	"hpp":           "cpp",
+	"kt":            "kotlin",
+	"ps1":           "powershell",
+	"pwsh":          "powershell",
+	"html":          "xml",
+	"xhtml":         "xml",
+	"patch":         "diff",
+	"txt":           "text",
+	"plain":         "text",
+	"plaintext":     "text",
+}
+
+// ParseCodeInfo parses the language followed by attributes as key=value,
+// where the value may be quoted. Attributes may be in braces and separated
+// by commas as well, e.g. `go {linenumbers=true, title="main.go"}`.
+func ParseCodeInfo(info []byte) CodeInfo {
+	ci := CodeInfo{Attributes: make(map[string]string)}
+	for i, field := range splitCodeInfo(string(info)) {
+		eq := strings.IndexByte(field, '=')
+		if eq < 0 {
+			if i == 0 {
+				ci.Language = field
+			}
+			continue
+		}
+		key := strings.ToLower(field[:eq])
+		ci.Attributes[key] = unquote(field[eq+1:])
+	}
+	if lang, ok := languageAliases[strings.ToLower(ci.Language)]; ok {
+		ci.Language = lang
+	}
+	return ci
+}
+
+// splitCodeInfo splits info at spaces and commas, and removes braces, except
+// within quotes.
+func splitCodeInfo(info string) []string {
+	var fields []string
+	var field strings.Builder
+	var quote rune
+	for _, c := range info {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+			field.WriteRune(c)
+		case c == '"' || c == '\'':
+			quote = c
+			field.WriteRune(c)
+		case c == '{' || c == '}':
+		case c == ',' || unicode.IsSpace(c):
+			if field.Len() > 0 {
+				fields = append(fields, field.String())
+				field.Reset()
+			}
+		default:
+			field.WriteRune(c)
+		}
+	}
+	if field.Len() > 0 {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// parameters returns attributes of the code macro that are given, in order.
+func (ci CodeInfo) parameters() [][2]string {
+	var params [][2]string
+	for _, name := range codeParameters {
+		if v, ok := ci.Attributes[name]; ok {
+			params = append(params, [2]string{name, v})
+		}
+	}
+	return params
+}
@@ -0,0 +1,83 @@
+package bf2confluence
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCodeInfo(t *testing.T) {
+	for _, tc := range []struct {
+		info string
+		want CodeInfo
+	}{
+		{"", CodeInfo{Attributes: map[string]string{}}},
+		{"sh", CodeInfo{"bash", map[string]string{}}},
+		{`python title="deploy.py" linenumbers=true`, CodeInfo{"python", map[string]string{"title": "deploy.py", "linenumbers": "true"}}},
+		{`go {linenumbers=true, title='main, go'}`, CodeInfo{"go", map[string]string{"title": "main, go", "linenumbers": "true"}}},
+		{"Title=x", CodeInfo{Attributes: map[string]string{"title": "x"}}},
+	} {
+		if got := ParseCodeInfo([]byte(tc.info)); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("ParseCodeInfo(%q) = %+v, want %+v", tc.info, got, tc.want)
+		}
+	}
+}
+
+func TestCodeBlocks(t *testing.T) {
+	testRender(t, []renderTest{
+		{
+			name:     "parameters",
+			markdown: "```sh title=\"deploy script\" linenumbers=true firstline=10 collapse=true theme=Midnight\nmake\n```\n",
+			wiki:     "{code:bash|title=deploy script|linenumbers=true|firstline=10|collapse=true|theme=Midnight}\nmake\n{code}\n\n",
+			storage: `<ac:structured-macro ac:name="code"><ac:parameter ac:name="language">bash</ac:parameter>` +
+				`<ac:parameter ac:name="title">deploy script</ac:parameter><ac:parameter ac:name="linenumbers">true</ac:parameter>` +
+				`<ac:parameter ac:name="firstline">10</ac:parameter><ac:parameter ac:name="collapse">true</ac:parameter>` +
+				`<ac:parameter ac:name="theme">Midnight</ac:parameter><ac:plain-text-body><![CDATA[make` + "\n" +
+				`]]></ac:plain-text-body></ac:structured-macro>` + "\n",
+		},
+		{
+			name:     "braces and end of CDATA",
+			markdown: "```golang {linenumbers=true, title='main.go'}\n]]>\n```\n",
+			wiki:     "{code:go|title=main.go|linenumbers=true}\n]]>\n{code}\n\n",
+			storage: `<ac:structured-macro ac:name="code"><ac:parameter ac:name="language">go</ac:parameter>` +
+				`<ac:parameter ac:name="title">main.go</ac:parameter><ac:parameter ac:name="linenumbers">true</ac:parameter>` +
+				`<ac:plain-text-body><![CDATA[]]]]><![CDATA[>` + "\n" + `]]></ac:plain-text-body></ac:structured-macro>` + "\n",
+		},
+		{
+			name:     "plain",
+			markdown: "```\nplain\n```\n",
+			wiki:     "{code}\nplain\n{code}\n\n",
+			storage:  `<ac:structured-macro ac:name="code"><ac:plain-text-body><![CDATA[plain` + "\n" + `]]></ac:plain-text-body></ac:structured-macro>` + "\n",
+		},
+		{
+			name:     "title ending parameters",
+			markdown: "```sh title=\"a|b}c=d<e>\"\nmake\n```\n",
+			wiki:     "{code:bash|title=a b c d<e>}\nmake\n{code}\n\n",
+			storage: `<ac:structured-macro ac:name="code"><ac:parameter ac:name="language">bash</ac:parameter>` +
+				`<ac:parameter ac:name="title">a|b}c=d&lt;e&gt;</ac:parameter><ac:plain-text-body><![CDATA[make` + "\n" +
+				`]]></ac:plain-text-body></ac:structured-macro>` + "\n",
+		},
+		{
+			name:     "information macro",
+			markdown: "```warning title=\"Careful|now\"\nDon't\n```\n",
+			flags:    InformationMacros,
+			wiki:     "{warning:title=Careful now}\nDon't\n{warning}\n\n",
+			storage: `<ac:structured-macro ac:name="warning"><ac:parameter ac:name="title">Careful|now</ac:parameter>` +
+				`<ac:rich-text-body><p>Don&#39;t</p></ac:rich-text-body></ac:structured-macro>` + "\n",
+		},
+		{
+			name:     "information macro disabled",
+			markdown: "```warning\nDon't\n```\n",
+			wiki:     "{code:warning}\nDon't\n{code}\n\n",
+			storage: `<ac:structured-macro ac:name="code"><ac:parameter ac:name="language">warning</ac:parameter>` +
+				`<ac:plain-text-body><![CDATA[Don't` + "\n" + `]]></ac:plain-text-body></ac:structured-macro>` + "\n",
+		},
+		{
+			name:     "raw wiki markup",
+			markdown: "```confluence\n{status:colour=Green}\n```\n",
+			flags:    RawConfluenceWiki,
+			wiki:     "{status:colour=Green}\n",
+			storage: `<ac:structured-macro ac:name="code"><ac:parameter ac:name="language">confluence</ac:parameter>` +
+				`<ac:plain-text-body><![CDATA[{status:colour=Green}` + "\n" + `]]></ac:plain-text-body></ac:structured-macro>` + "\n",
+		},
+	})
+}
@@ -0,0 +1,185 @@
+package bf2confluence
+
+import (
+	"bytes"
+	"strings"
+
+	bf "github.com/russross/blackfriday/v2"
+)
+
+// details is a collapsible block written in HTML, which blackfriday parses
+// into paragraphs of HTML spans around the blocks in it:
+//
+//	<details>
+//	<summary>Title</summary>
+//
+//	Markdown
+//
+//	</details>
+type details struct {
+	title string
+	lead  []*bf.Node // Inline nodes following the summary in the first paragraph
+	trail []*bf.Node // Inline nodes preceding </details> in the last paragraph
+	start *bf.Node   // The first paragraph
+	end   *bf.Node   // The last paragraph, which may be the first one
+}
+
+// eachBlock calls f with each block between the first and last paragraph.
+// They are not collected in advance, as paragraphs may be split while
+// rendering, e.g. at the end of nested details.
+func (d *details) eachBlock(f func(*bf.Node)) {
+	if d.start == d.end {
+		return
+	}
+	for n := d.start.Next; n != d.end; n = n.Next {
+		f(n)
+	}
+}
+
+// isTag reports whether node is an HTML tag of the given name, e.g. "details"
+// or "/details".
+func isTag(node *bf.Node, name string) bool {
+	if node.Type != bf.HTMLSpan && node.Type != bf.HTMLBlock {
+		return false
+	}
+	lit := strings.ToLower(strings.TrimSpace(string(node.Literal)))
+	return lit == "<"+name+">" || strings.HasPrefix(lit, "<"+name+" ")
+}
+
+func isBlank(node *bf.Node) bool {
+	return node.Type == bf.Text && strings.TrimSpace(string(node.Literal)) == ""
+}
+
+func children(node *bf.Node) []*bf.Node {
+	var nodes []*bf.Node
+	for n := node.FirstChild; n != nil; n = n.Next {
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+// nextTag returns the index of the first non-blank node from i, if it is an
+// HTML tag of the given name, or -1.
+func nextTag(nodes []*bf.Node, i int, name string) int {
+	for ; i < len(nodes) && isBlank(nodes[i]); i++ {
+	}
+	if i < len(nodes) && isTag(nodes[i], name) {
+		return i
+	}
+	return -1
+}
+
+// plainText returns the text of nodes without formatting.
+func plainText(nodes []*bf.Node) string {
+	var b strings.Builder
+	for _, n := range nodes {
+		n.Walk(func(node *bf.Node, entering bool) bf.WalkStatus {
+			if node.Type == bf.Text || node.Type == bf.Code {
+				b.Write(node.Literal)
+			}
+			return bf.GoToNext
+		})
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// parseDetails returns the details block starting at para, or nil if para
+// doesn't start with <details> or it is not closed.
+func parseDetails(para *bf.Node) *details {
+	if para.Type != bf.Paragraph {
+		return nil
+	}
+	nodes := children(para)
+	i := nextTag(nodes, 0, "details")
+	if i < 0 {
+		return nil
+	}
+	d := &details{start: para}
+	i++
+	if j := nextTag(nodes, i, "summary"); j >= 0 {
+		k := j + 1
+		for k < len(nodes) && !isTag(nodes[k], "/summary") {
+			k++
+		}
+		d.title = plainText(nodes[j+1 : k])
+		if i = k; i < len(nodes) {
+			i++
+		}
+	}
+
+	depth := 1
+	for n, first := para, true; n != nil; n, first = n.Next, false {
+		if n.Type != bf.Paragraph {
+			continue
+		}
+		nodes := children(n)
+		start := 0
+		if first {
+			start = i
+		}
+		for k := start; k < len(nodes); k++ {
+			if isTag(nodes[k], "details") {
+				depth++
+			} else if isTag(nodes[k], "/details") {
+				depth--
+			}
+			if depth == 0 {
+				if first {
+					d.lead = trimInline(nodes[start:k])
+				} else {
+					d.trail = trimInline(nodes[:k])
+				}
+				d.end = n
+				splitParagraph(n, nodes[k+1:])
+				return d
+			}
+		}
+		if first {
+			d.lead = trimInline(nodes[start:])
+		}
+	}
+	return nil
+}
+
+// splitParagraph moves rest, the inline nodes following </details> in para,
+// into a paragraph of their own after it, so that they are rendered after the
+// details block.
+func splitParagraph(para *bf.Node, rest []*bf.Node) {
+	if blank(rest) {
+		return
+	}
+	after := bf.NewNode(bf.Paragraph)
+	for _, n := range trimInline(rest) {
+		after.AppendChild(n)
+	}
+	if para.Next != nil {
+		para.Next.InsertBefore(after)
+	} else {
+		para.Parent.AppendChild(after)
+	}
+}
+
+// trimInline removes white spaces around nodes, which are insignificant as
+// they are rendered as a paragraph.
+func trimInline(nodes []*bf.Node) []*bf.Node {
+	if len(nodes) == 0 {
+		return nodes
+	}
+	if first := nodes[0]; first.Type == bf.Text {
+		first.Literal = bytes.TrimLeft(first.Literal, " \t\n")
+	}
+	if last := nodes[len(nodes)-1]; last.Type == bf.Text {
+		last.Literal = bytes.TrimRight(last.Literal, " \t\n")
+	}
+	return nodes
+}
+
+// blank reports whether nodes have no content to render.
+func blank(nodes []*bf.Node) bool {
+	for _, n := range nodes {
+		if !isBlank(n) {
+			return false
+		}
+	}
+	return true
+}
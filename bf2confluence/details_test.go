@@ -0,0 +1,57 @@
+package bf2confluence
+
+import "testing"
+
+func TestDetails(t *testing.T) {
+	expand := func(title, body string) string {
+		s := `<ac:structured-macro ac:name="expand">`
+		if title != "" {
+			s += `<ac:parameter ac:name="title">` + title + `</ac:parameter>`
+		}
+		return s + `<ac:rich-text-body>` + body + `</ac:rich-text-body></ac:structured-macro>` + "\n"
+	}
+	testRender(t, []renderTest{
+		{
+			name:     "nested",
+			markdown: "<details>\n<summary>Logs *here*</summary>\n\n- item\n\n<details><summary>Inner</summary>\nnested\n</details>\n\n</details>\n",
+			wiki:     "{expand:title=Logs here}\n* item\n\n{expand:title=Inner}\nnested\n\n{expand}\n\n{expand}\n\n",
+			storage:  expand("Logs here", "<ul><li><p>item</p>\n</li></ul>"+expand("Inner", "<p>nested</p>")),
+		},
+		{
+			name:     "title ending parameters",
+			markdown: "<details>\n<summary>a|b}c=d</summary>\n\nText\n\n</details>\n",
+			wiki:     "{expand:title=a b c d}\nText\n\n{expand}\n\n",
+			storage:  expand("a|b}c=d", "<p>Text</p>\n"),
+		},
+		{
+			name:     "no summary",
+			markdown: "<details>\n\nNo summary\n\n</details>\n",
+			wiki:     "{expand}\nNo summary\n\n{expand}\n\n",
+			storage:  expand("", "<p>No summary</p>\n"),
+		},
+		{
+			name:     "inline",
+			markdown: "Before\n\n<details><summary>S</summary>inline</details>\n\nAfter\n",
+			wiki:     "Before\n\n{expand:title=S}\ninline\n\n{expand}\n\nAfter\n\n",
+			storage:  "<p>Before</p>\n" + expand("S", "<p>inline</p>") + "<p>After</p>\n",
+		},
+		{
+			name:     "text after",
+			markdown: "<details><summary>X</summary>hidden</details> and more text\n",
+			wiki:     "{expand:title=X}\nhidden\n\n{expand}\n\nand more text\n\n",
+			storage:  expand("X", "<p>hidden</p>") + "<p>and more text</p>\n",
+		},
+		{
+			name:     "text after on next line",
+			markdown: "<details>\n<summary>X</summary>\n\nhidden\n</details>\nAfter text\n",
+			wiki:     "{expand:title=X}\nhidden\n\n{expand}\n\nAfter text\n\n",
+			storage:  expand("X", "<p>hidden</p>") + "<p>After text</p>\n",
+		},
+		{
+			name:     "text after nested",
+			markdown: "<details><summary>Outer</summary>\n\n<details><summary>Inner</summary>x</details> y\n\n</details>\n",
+			wiki:     "{expand:title=Outer}\n{expand:title=Inner}\nx\n\n{expand}\n\ny\n\n{expand}\n\n",
+			storage:  expand("Outer", expand("Inner", "<p>x</p>")+"<p>y</p>\n"),
+		},
+	})
+}
@@ -2,7 +2,11 @@ package bf2confluence
 
 import (
 	"bytes"
+	"fmt"
 	"io"
+	"net/url"
+	"path"
+	"regexp"
 	"strings"
 
 	bf "github.com/russross/blackfriday/v2"
@@ -15,7 +19,14 @@ type Renderer struct {
 	// Flags allow customizing this renderer's behavior.
 	Flags Flag
 
+	// Header and Footer are written verbatim before and after the document,
+	// e.g. a banner telling the page is generated.
+	Header []byte
+	Footer []byte
+
 	lastOutputLen int
+	inTable       bool
+	skipTo        *bf.Node // Last node rendered ahead, e.g. end of details
 }
 
 // Flag control optional behavior of this renderer.
@@ -28,6 +39,14 @@ const (
 	// InformationMacros allow using info, tip, note, and warning macros
 	InformationMacros Flag = 1 << iota
 	RawConfluenceWiki
+
+	// HeadingAnchors puts an anchor macro named after the heading ID at each
+	// heading, so that links to #fragment work in Confluence
+	HeadingAnchors
+
+	// DefinitionTables renders definition lists as tables of terms and
+	// definitions instead of bold terms followed by lists of definitions
+	DefinitionTables
 )
 
 var (
@@ -55,6 +74,15 @@ var (
 	h6Tag            = []byte("h6.")
 )
 
+// informationMacros are languages of code blocks rendered as the macros
+// with InformationMacros.
+var informationMacros = map[string][]byte{
+	"info":    infoTag,
+	"tip":     tipTag,
+	"note":    noteTag,
+	"warning": warningTag,
+}
+
 var (
 	nlBytes    = []byte{'\n'}
 	spaceBytes = []byte{' '}
@@ -77,10 +105,46 @@ var confluenceEscaper = [256][]byte{
 	')': []byte(`\)`),
 }
 
+// tableEscaper escapes characters which break table cells.
+var tableEscaper = [256][]byte{
+	'|':  []byte(`\|`),
+	'\n': spaceBytes,
+}
+
+// lineBreak matches HTML line breaks, which are allowed in table cells.
+var lineBreak = regexp.MustCompile(`(?i)^<br\s*/?>$`)
+
+// reHTMLTag matches start and end tags which are well-formed XML once void
+// elements are closed, i.e. with quoted attribute values having no bare &.
+var reHTMLTag = regexp.MustCompile(`^<(/?)([a-zA-Z][a-zA-Z0-9-]*)` +
+	`(?:\s+[a-zA-Z_:][-a-zA-Z0-9_:.]*\s*=\s*(?:"(?:[^"<&]|&#?\w+;)*"|'(?:[^'<&]|&#?\w+;)*'))*\s*(/?)>$`)
+
+// reHTMLComment matches HTML comments which are well-formed XML.
+var reHTMLComment = regexp.MustCompile(`^<!--(?:[^-]|-[^-])*-->$`)
+
+// voidElements are HTML elements without end tags.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
+
+// reBlankLines matches lines separating paragraphs of plain text.
+var reBlankLines = regexp.MustCompile(`\n[ \t]*\n\s*`)
+
+// macroParamReplacer removes characters which end parameters of macros.
+var macroParamReplacer = strings.NewReplacer("|", " ", "}", " ", "=", " ")
+
+// imageAttributeReplacer removes characters which end attributes of images.
+var imageAttributeReplacer = strings.NewReplacer("|", " ", "!", " ", ",", " ")
+
 func (r *Renderer) esc(w io.Writer, text []byte) {
 	var start, end int
 	for end < len(text) {
-		if escSeq := confluenceEscaper[text[end]]; escSeq != nil {
+		escSeq := confluenceEscaper[text[end]]
+		if escSeq == nil && r.inTable {
+			escSeq = tableEscaper[text[end]]
+		}
+		if escSeq != nil {
 			w.Write(text[start:end])
 			w.Write(escSeq)
 			start = end + 1
@@ -91,6 +155,9 @@ func (r *Renderer) esc(w io.Writer, text []byte) {
 	if start < len(text) && end <= len(text) {
 		w.Write(text[start:end])
 	}
+	if len(text) > 0 {
+		r.lastOutputLen = len(text)
+	}
 }
 
 func (r *Renderer) cr(w io.Writer) {
@@ -104,6 +171,47 @@ func (r *Renderer) out(w io.Writer, text []byte) {
 	r.lastOutputLen = len(text)
 }
 
+func (r *Renderer) anchor(w io.Writer, name string) {
+	r.out(w, []byte("{anchor:"+name+"}"))
+}
+
+// attachmentName returns the name a local file referred to by dest is
+// attached to the page as, or "" if dest is a URL or an absolute path.
+func attachmentName(dest []byte) string {
+	if _, err := url.ParseRequestURI(string(dest)); err == nil {
+		return ""
+	}
+	return path.Base(string(dest))
+}
+
+// altText returns the plain text of the description of an image.
+func altText(image *bf.Node) string {
+	var b strings.Builder
+	image.Walk(func(node *bf.Node, entering bool) bf.WalkStatus {
+		if entering && (node.Type == bf.Text || node.Type == bf.Code) {
+			b.Write(node.Literal)
+		}
+		return bf.GoToNext
+	})
+	return b.String()
+}
+
+// footnoteID returns the anchor name of a footnote, and footnoteRefID that of
+// the reference to it.
+func footnoteID(label []byte) string {
+	return "fn-" + bf.SanitizedAnchorName(string(label))
+}
+
+func footnoteRefID(label []byte) string {
+	return "fnref-" + bf.SanitizedAnchorName(string(label))
+}
+
+// footnotesTitle is the heading of footnotes at the end of the document.
+const footnotesTitle = "Notes"
+
+// footnoteBackLink is the text of links from footnotes back to references.
+const footnoteBackLink = "\u21a9"
+
 func headingTagFromLevel(level int) []byte {
 	switch level {
 	case 1:
@@ -123,6 +231,13 @@ func headingTagFromLevel(level int) []byte {
 
 // RenderNode is a bf2confluence renderer of a single node of a syntax tree.
 func (r *Renderer) RenderNode(w io.Writer, node *bf.Node, entering bool) bf.WalkStatus {
+	if r.skipTo != nil {
+		if node == r.skipTo {
+			r.skipTo = nil
+		}
+		return bf.SkipChildren
+	}
+
 	switch node.Type {
 	case bf.Text:
 		r.esc(w, node.Literal)
@@ -140,66 +255,39 @@ func (r *Renderer) RenderNode(w io.Writer, node *bf.Node, entering bool) bf.Walk
 			r.cr(w)
 		}
 	case bf.CodeBlock:
-		if len(node.Info) > 0 && string(node.Info) == "confluence" && r.Flags&RawConfluenceWiki != 0 {
+		info := ParseCodeInfo(node.Info)
+		if info.Language == "confluence" && r.Flags&RawConfluenceWiki != 0 {
 			w.Write(node.Literal)
 			break
 		}
 
-		r.out(w, []byte("{"))
-		if len(node.Info) > 0 {
-			language := string(node.Info)
-			if r.Flags&InformationMacros != 0 {
-				switch language {
-				case "info":
-					r.out(w, infoTag)
-				case "tip":
-					r.out(w, tipTag)
-				case "note":
-					r.out(w, noteTag)
-				case "warning":
-					r.out(w, warningTag)
-				case "confluence":
-				default:
-					r.out(w, []byte(codeTag))
-					r.out(w, []byte(":"))
-					r.out(w, node.Info)
-				}
-				r.out(w, []byte("}"))
-				r.cr(w)
-				w.Write(node.Literal)
-				r.out(w, []byte("{"))
-				switch language {
-				case "info":
-					r.out(w, infoTag)
-				case "tip":
-					r.out(w, tipTag)
-				case "note":
-					r.out(w, noteTag)
-				case "warning":
-					r.out(w, warningTag)
-				default:
-					r.out(w, []byte(codeTag))
-				}
-			} else {
-				r.out(w, []byte(codeTag))
-				r.out(w, []byte(":"))
-				r.out(w, node.Info)
-				r.out(w, []byte("}"))
-				r.cr(w)
-				w.Write(node.Literal)
-				r.out(w, []byte("{"))
-				r.out(w, []byte(codeTag))
+		macro := codeTag
+		var params []string
+		if tag, ok := informationMacros[info.Language]; ok && r.Flags&InformationMacros != 0 {
+			macro = tag
+			if title, ok := info.Attributes["title"]; ok {
+				params = append(params, "title="+macroParamReplacer.Replace(title))
 			}
 		} else {
-			r.out(w, codeTag)
-			r.out(w, []byte("}"))
-			r.cr(w)
-			w.Write(node.Literal)
-			r.out(w, []byte("{"))
-			r.out(w, codeTag)
+			if info.Language != "" {
+				params = append(params, macroParamReplacer.Replace(info.Language))
+			}
+			for _, p := range info.parameters() {
+				params = append(params, p[0]+"="+macroParamReplacer.Replace(p[1]))
+			}
+		}
+		r.out(w, []byte("{"))
+		r.out(w, macro)
+		if len(params) > 0 {
+			r.out(w, []byte(":"+strings.Join(params, "|")))
 		}
 		r.out(w, []byte("}"))
 		r.cr(w)
+		w.Write(node.Literal)
+		r.out(w, []byte("{"))
+		r.out(w, macro)
+		r.out(w, []byte("}"))
+		r.cr(w)
 		r.cr(w)
 	case bf.Code:
 		r.out(w, []byte("{{"))
@@ -212,19 +300,29 @@ func (r *Renderer) RenderNode(w io.Writer, node *bf.Node, entering bool) bf.Walk
 		if entering {
 			r.out(w, headingTag)
 			w.Write(spaceBytes)
+			if r.Flags&HeadingAnchors != 0 && node.HeadingID != "" {
+				r.anchor(w, node.HeadingID)
+			}
 		} else {
 			r.cr(w)
 		}
 	case bf.Image:
-		if entering {
-			dest := node.LinkData.Destination
-			r.out(w, imageTag)
-			r.out(w, dest)
+		// Written on entering, as the description is an attribute
+		r.out(w, imageTag)
+		if name := attachmentName(node.LinkData.Destination); name != "" {
+			r.out(w, []byte(name))
 		} else {
-			r.out(w, imageTag)
+			r.out(w, node.LinkData.Destination)
+		}
+		if alt := imageAttributeReplacer.Replace(altText(node)); strings.TrimSpace(alt) != "" {
+			r.out(w, []byte("|alt="+alt))
 		}
+		r.out(w, imageTag)
+		return bf.SkipChildren
 	case bf.Item:
-		if entering {
+		if node.ListFlags&bf.ListTypeDefinition != 0 {
+			r.definitionItem(w, node, entering)
+		} else if entering {
 			itemTag := liTag
 			if node.ListFlags&bf.ListTypeOrdered != 0 {
 				itemTag = olTag
@@ -235,8 +333,22 @@ func (r *Renderer) RenderNode(w io.Writer, node *bf.Node, entering bool) bf.Walk
 			}
 
 			w.Write(spaceBytes)
+			if node.RefLink != nil {
+				r.anchor(w, footnoteID(node.RefLink))
+			}
+		} else if node.RefLink != nil {
+			r.out(w, []byte(" ["+footnoteBackLink+"|#"+footnoteRefID(node.RefLink)+"]"))
+			r.cr(w)
 		}
 	case bf.Link:
+		if node.NoteID != 0 {
+			// Footnote reference, which has no children
+			if entering {
+				r.anchor(w, footnoteRefID(node.Destination))
+				r.out(w, []byte(fmt.Sprintf("^[%d|#%s]^", node.NoteID, footnoteID(node.Destination))))
+			}
+			break
+		}
 		if entering {
 			r.out(w, linkTag)
 		} else {
@@ -251,6 +363,15 @@ func (r *Renderer) RenderNode(w io.Writer, node *bf.Node, entering bool) bf.Walk
 		r.out(w, hrTag)
 		r.cr(w)
 	case bf.List:
+		if entering && node.IsFootnotesList {
+			r.cr(w)
+			r.out(w, hrTag)
+			r.cr(w)
+			r.out(w, h2Tag)
+			w.Write(spaceBytes)
+			r.out(w, []byte(footnotesTitle))
+			r.cr(w)
+		}
 		if entering {
 			itemLevel++
 		} else {
@@ -262,7 +383,19 @@ func (r *Renderer) RenderNode(w io.Writer, node *bf.Node, entering bool) bf.Walk
 	case bf.Document:
 		break
 	case bf.Paragraph:
-		if !entering {
+		if d := parseDetails(node); d != nil && entering {
+			r.details(w, d)
+			if d.end != node {
+				r.skipTo = d.end
+			}
+			return bf.SkipChildren
+		}
+		if node.Parent.Type == bf.Item && node.Parent.ListFlags&bf.ListTypeDefinition != 0 {
+			r.definitionParagraph(w, node, entering)
+		} else if !entering {
+			if node.Parent.RefLink != nil && node.Next == nil {
+				break // back link follows in the same line
+			}
 			if node.Parent.Type != bf.Item {
 				r.cr(w)
 			}
@@ -273,6 +406,7 @@ func (r *Renderer) RenderNode(w io.Writer, node *bf.Node, entering bool) bf.Walk
 	case bf.Del:
 		r.out(w, strikethroughTag)
 	case bf.Table:
+		r.inTable = entering
 		if !entering {
 			r.cr(w)
 		}
@@ -301,32 +435,222 @@ func (r *Renderer) RenderNode(w io.Writer, node *bf.Node, entering bool) bf.Walk
 				r.cr(w)
 			}
 		}
-	case bf.HTMLBlock, bf.HTMLSpan:
-		r.out(w, node.Literal)
+	case bf.HTMLSpan:
+		if lineBreak.Match(node.Literal) {
+			r.out(w, []byte(`\\`))
+		} else {
+			r.out(w, node.Literal)
+		}
+	case bf.HTMLBlock:
+		r.out(w, bytes.TrimRight(node.Literal, "\n"))
+		r.cr(w)
+		r.cr(w)
 	case bf.Macro:
+		if !entering {
+			r.out(w, []byte("{"+node.Name+"}"))
+			r.cr(w)
+			r.cr(w)
+			break
+		}
 		s := strings.ReplaceAll(string(node.Literal), "%", "|")
 		r.out(w, []byte(s))
+		if node.FirstChild != nil {
+			r.cr(w)
+		}
 	default:
 		panic("Unknown node type " + node.Type.String())
 	}
 	return bf.GoToNext
 }
 
+// NeedsStorageFormat reports whether ast has tables which wiki markup can't
+// represent, i.e. with columns aligned to the center or right, or with
+// well-formed HTML other than line breaks in cells. They need to be rendered by XmlRenderer.
+func NeedsStorageFormat(ast *bf.Node) bool {
+	needed := false
+	ast.Walk(func(node *bf.Node, entering bool) bf.WalkStatus {
+		switch {
+		case node.Type == bf.TableCell && (node.Align == bf.TableAlignmentRight || node.Align == bf.TableAlignmentCenter):
+			needed = true
+		case node.Type == bf.HTMLSpan && !lineBreak.Match(node.Literal) && inTableCell(node) && wellFormedHTML(node):
+			needed = true
+		}
+		if needed {
+			return bf.Terminate
+		}
+		return bf.GoToNext
+	})
+	return needed
+}
+
+// wellFormedHTML reports whether the HTML spans of the block containing span
+// are well-formed XML once void elements are closed: each tag is valid, and
+// elements are closed in order within the same parent node.
+func wellFormedHTML(span *bf.Node) bool {
+	block := span.Parent
+	for block.Parent != nil && block.Type != bf.Paragraph && block.Type != bf.TableCell && block.Type != bf.Heading {
+		block = block.Parent
+	}
+	type element struct {
+		name   string
+		parent *bf.Node
+	}
+	var open []element
+	ok := true
+	block.Walk(func(node *bf.Node, entering bool) bf.WalkStatus {
+		if !entering || node.Type != bf.HTMLSpan || lineBreak.Match(node.Literal) || reHTMLComment.Match(node.Literal) {
+			return bf.GoToNext
+		}
+		m := reHTMLTag.FindSubmatch(node.Literal)
+		if m == nil {
+			ok = false
+			return bf.Terminate
+		}
+		name := strings.ToLower(string(m[2]))
+		switch {
+		case len(m[1]) > 0:
+			if len(open) == 0 || open[len(open)-1] != (element{name, node.Parent}) {
+				ok = false
+				return bf.Terminate
+			}
+			open = open[:len(open)-1]
+		case len(m[3]) == 0 && !voidElements[name]:
+			open = append(open, element{name, node.Parent})
+		}
+		return bf.GoToNext
+	})
+	return ok && len(open) == 0
+}
+
+// closeVoidElement returns the tag of a void element with the self-closing
+// slash XML requires, and other tags as they are.
+func closeVoidElement(tag []byte) []byte {
+	m := reHTMLTag.FindSubmatch(tag)
+	if m == nil || len(m[1]) > 0 || len(m[3]) > 0 || !voidElements[strings.ToLower(string(m[2]))] {
+		return tag
+	}
+	return append(append([]byte{}, tag[:len(tag)-1]...), " />"...)
+}
+
+func inTableCell(node *bf.Node) bool {
+	for n := node.Parent; n != nil; n = n.Parent {
+		if n.Type == bf.TableCell {
+			return true
+		}
+	}
+	return false
+}
+
+// details writes the details block as an expand macro.
+func (r *Renderer) details(w io.Writer, d *details) {
+	render := func(node *bf.Node, entering bool) bf.WalkStatus {
+		return r.RenderNode(w, node, entering)
+	}
+	inline := func(nodes []*bf.Node) {
+		if blank(nodes) {
+			return
+		}
+		for _, n := range nodes {
+			n.Walk(render)
+		}
+		r.cr(w)
+		r.cr(w)
+	}
+
+	r.out(w, []byte("{expand"))
+	if title := macroParamReplacer.Replace(d.title); title != "" {
+		r.out(w, []byte(":title="+title))
+	}
+	r.out(w, []byte("}"))
+	r.cr(w)
+	inline(d.lead)
+	d.eachBlock(func(n *bf.Node) {
+		n.Walk(render)
+	})
+	inline(d.trail)
+	r.out(w, []byte("{expand}"))
+	r.cr(w)
+	r.cr(w)
+}
+
+func isDefinition(node *bf.Node) bool {
+	return node != nil && node.ListFlags&(bf.ListTypeDefinition|bf.ListTypeTerm) == bf.ListTypeDefinition
+}
+
+// definitionItem writes a term in bold followed by its definitions as list
+// items, or with DefinitionTables, a table row of the term and definitions.
+func (r *Renderer) definitionItem(w io.Writer, node *bf.Node, entering bool) {
+	term := node.ListFlags&bf.ListTypeTerm != 0
+	if r.Flags&DefinitionTables != 0 {
+		switch {
+		case term && entering:
+			r.out(w, tableTag)
+			r.out(w, tableTag)
+		case term:
+			r.out(w, tableTag)
+		case entering && isDefinition(node.Prev):
+			r.out(w, []byte(" \\\\ "))
+		case !entering && !isDefinition(node.Next):
+			r.out(w, tableTag)
+			r.cr(w)
+		}
+		return
+	}
+
+	switch {
+	case term:
+		r.out(w, strongTag)
+		if !entering {
+			r.cr(w)
+		}
+	case entering:
+		for i := 0; i < itemLevel; i++ {
+			r.out(w, liTag)
+		}
+		w.Write(spaceBytes)
+	}
+}
+
+// definitionParagraph writes a paragraph in a term or definition, which
+// definitionItem ends a line after.
+func (r *Renderer) definitionParagraph(w io.Writer, node *bf.Node, entering bool) {
+	term := node.Parent.ListFlags&bf.ListTypeTerm != 0
+	switch {
+	case term:
+	case r.Flags&DefinitionTables != 0:
+		if entering && node.Prev != nil {
+			r.out(w, []byte(" \\\\ "))
+		}
+	case !entering:
+		r.cr(w)
+	}
+}
+
 // Render prints out the whole document from the ast.
 func (r *Renderer) Render(ast *bf.Node) []byte {
+	r.RenderHeader(&r.w, ast)
 	ast.Walk(func(node *bf.Node, entering bool) bf.WalkStatus {
 		return r.RenderNode(&r.w, node, entering)
 	})
+	r.RenderFooter(&r.w, ast)
 
 	return r.w.Bytes()
 }
 
-// RenderHeader writes document header (unused).
+// RenderHeader writes Header, if any, followed by a new line.
 func (r *Renderer) RenderHeader(w io.Writer, ast *bf.Node) {
+	if len(r.Header) > 0 {
+		r.out(w, bytes.TrimRight(r.Header, "\n"))
+		r.cr(w)
+	}
 }
 
-// RenderFooter writes document footer (unused).
+// RenderFooter writes Footer, if any, followed by a new line.
 func (r *Renderer) RenderFooter(w io.Writer, ast *bf.Node) {
+	if len(r.Footer) > 0 {
+		r.out(w, bytes.TrimRight(r.Footer, "\n"))
+		r.cr(w)
+	}
 }
 
 // Run prints out the bf2confluence document.
@@ -0,0 +1,288 @@
+package bf2confluence
+
+import (
+	"testing"
+
+	bf "github.com/russross/blackfriday/v2"
+)
+
+// testExtensions are those md2cfl parses markdown with.
+const testExtensions = bf.CommonExtensions&^bf.Autolink | bf.AutoHeadingIDs | bf.Footnotes
+
+func parse(markdown string) *bf.Node {
+	return bf.New(bf.WithExtensions(testExtensions)).Parse([]byte(markdown))
+}
+
+func renderWiki(markdown string, flags Flag) string {
+	r := &Renderer{Flags: flags}
+	return string(r.Render(parse(markdown)))
+}
+
+// renderTest is markdown, or a document built by doc, and what it is rendered
+// to in wiki markup and in storage format.
+type renderTest struct {
+	name     string
+	markdown string
+	doc      func() *bf.Node
+	flags    Flag
+	header   string
+	footer   string
+	wiki     string
+	storage  string
+}
+
+func testRender(t *testing.T, tests []renderTest) {
+	t.Helper()
+	for _, tc := range tests {
+		doc := tc.doc
+		if doc == nil {
+			markdown := tc.markdown
+			doc = func() *bf.Node { return parse(markdown) }
+		}
+		r := Renderer{Flags: tc.flags, Header: []byte(tc.header), Footer: []byte(tc.footer)}
+		if got := string(r.Render(doc())); got != tc.wiki {
+			t.Errorf("%s: wiki\n got %q\nwant %q", tc.name, got, tc.wiki)
+		}
+		xr := XmlRenderer{Renderer: Renderer{Flags: tc.flags, Header: []byte(tc.header), Footer: []byte(tc.footer)}}
+		if got := string(xr.Render(doc())); got != tc.storage {
+			t.Errorf("%s: storage\n got %q\nwant %q", tc.name, got, tc.storage)
+		}
+	}
+}
+
+// TestTextOnlyParagraphs checks that paragraphs of plain text are separated,
+// which needs esc to record its output for cr.
+func TestTextOnlyParagraphs(t *testing.T) {
+	for _, tc := range []struct {
+		markdown string
+		want     string
+	}{
+		{"One\n\nTwo\n", "One\n\nTwo\n\n"},
+		{"Hello\n\n{attachments}\n", "Hello\n\n{attachments}\n\n"},
+	} {
+		if got := renderWiki(tc.markdown, 0); got != tc.want {
+			t.Errorf("renderWiki(%q) = %q, want %q", tc.markdown, got, tc.want)
+		}
+	}
+}
+
+func TestBanners(t *testing.T) {
+	testRender(t, []renderTest{
+		{
+			name:     "header and footer",
+			markdown: "Hello\n",
+			header:   "Generated\n\n",
+			footer:   "Do not edit",
+			wiki:     "Generated\nHello\n\nDo not edit\n",
+			storage:  "Generated\n<p>Hello</p>\nDo not edit\n",
+		},
+		{
+			name:     "none",
+			markdown: "Hello\n",
+			wiki:     "Hello\n\n",
+			storage:  "<p>Hello</p>\n",
+		},
+	})
+}
+
+// macroParagraph returns a paragraph of a macro as md2cfl adds, e.g. for a
+// table of contents, with blocks parsed from body if any.
+func macroParagraph(name string, params map[string]string, literal, body string) *bf.Node {
+	macro := bf.NewNode(bf.Macro)
+	macro.Name = name
+	macro.Parameters = params
+	macro.Literal = []byte(literal)
+	if body != "" {
+		doc := parse(body)
+		for n := doc.FirstChild; n != nil; n = doc.FirstChild {
+			macro.AppendChild(n)
+		}
+	}
+	para := bf.NewNode(bf.Paragraph)
+	para.AppendChild(macro)
+	return para
+}
+
+func TestMacros(t *testing.T) {
+	testRender(t, []renderTest{
+		{
+			name:     "parameters",
+			markdown: "{toc:maxLevel=3|minLevel=2}\n",
+			wiki:     "{toc:maxLevel=3|minLevel=2}\n\n",
+			storage: `<p><ac:structured-macro ac:name="toc"><ac:parameter ac:name="maxLevel"><![CDATA[3]]></ac:parameter>` +
+				`<ac:parameter ac:name="minLevel"><![CDATA[2]]></ac:parameter></ac:structured-macro></p>` + "\n",
+		},
+		{
+			name: "added",
+			doc: func() *bf.Node {
+				doc := bf.NewNode(bf.Document)
+				doc.AppendChild(macroParagraph("toc", nil, "{toc}", ""))
+				return doc
+			},
+			wiki:    "{toc}\n\n",
+			storage: `<p><ac:structured-macro ac:name="toc"></ac:structured-macro></p>` + "\n",
+		},
+		{
+			name: "body",
+			doc: func() *bf.Node {
+				doc := parse("Rest\n")
+				excerpt := macroParagraph("excerpt", map[string]string{"hidden": "true"}, "{excerpt:hidden=true}", "Lead *text*.\n\n- item\n").FirstChild
+				excerpt.Unlink()
+				doc.FirstChild.InsertBefore(excerpt)
+				return doc
+			},
+			wiki: "{excerpt:hidden=true}\nLead _text_.\n\n* item\n\n{excerpt}\n\nRest\n\n",
+			storage: `<ac:structured-macro ac:name="excerpt"><ac:parameter ac:name="hidden"><![CDATA[true]]></ac:parameter>` +
+				`<ac:rich-text-body><p>Lead <em>text</em>.</p>` + "\n" + `<ul><li><p>item</p>` + "\n" + `</li></ul></ac:rich-text-body></ac:structured-macro>` + "\n" +
+				"<p>Rest</p>\n",
+		},
+		{
+			name: "page",
+			doc: func() *bf.Node {
+				doc := bf.NewNode(bf.Document)
+				doc.AppendChild(macroParagraph("excerpt-include", map[string]string{"": "OPS:Run & book", "nopanel": "true"},
+					"{excerpt-include:OPS:Run & book|nopanel=true}", ""))
+				doc.AppendChild(macroParagraph("include", map[string]string{"": "Home"}, "{include:Home}", ""))
+				return doc
+			},
+			wiki: "{excerpt-include:OPS:Run & book|nopanel=true}\n\n{include:Home}\n\n",
+			storage: `<p><ac:structured-macro ac:name="excerpt-include"><ac:parameter ac:name=""><ac:link><ri:page ri:space-key="OPS" ri:content-title="Run &amp; book" /></ac:link></ac:parameter>` +
+				`<ac:parameter ac:name="nopanel"><![CDATA[true]]></ac:parameter></ac:structured-macro></p>` + "\n" +
+				`<p><ac:structured-macro ac:name="include"><ac:parameter ac:name=""><ac:link><ri:page ri:content-title="Home" /></ac:link></ac:parameter></ac:structured-macro></p>` + "\n",
+		},
+	})
+}
+
+func TestHeadingAnchors(t *testing.T) {
+	markdown := "# Setup\n## Rollback procedure {#rollback}\n[see below](#rollback) and [site](http://example.com/?a=1&b=2)\n"
+	link := `<p><ac:link ac:anchor="rollback"><ac:link-body>see below</ac:link-body></ac:link> and <a href="http://example.com/?a=1&amp;b=2">site</a></p>` + "\n"
+	testRender(t, []renderTest{
+		{
+			name:     "anchors",
+			markdown: markdown,
+			flags:    HeadingAnchors,
+			wiki:     "h1. {anchor:setup}Setup\nh2. {anchor:rollback}Rollback procedure\n[see below|#rollback] and [site|http://example.com/?a=1&b=2]\n\n",
+			storage: `<h1><ac:structured-macro ac:name="anchor"><ac:parameter ac:name="">setup</ac:parameter></ac:structured-macro>Setup</h1>` + "\n" +
+				`<h2><ac:structured-macro ac:name="anchor"><ac:parameter ac:name="">rollback</ac:parameter></ac:structured-macro>Rollback procedure</h2>` + "\n" + link,
+		},
+		{
+			name:     "no anchors",
+			markdown: markdown,
+			wiki:     "h1. Setup\nh2. Rollback procedure\n[see below|#rollback] and [site|http://example.com/?a=1&b=2]\n\n",
+			storage:  "<h1>Setup</h1>\n<h2>Rollback procedure</h2>\n" + link,
+		},
+	})
+}
+
+func TestFootnotes(t *testing.T) {
+	anchor := func(name string) string {
+		return `<ac:structured-macro ac:name="anchor"><ac:parameter ac:name="">` + name + `</ac:parameter></ac:structured-macro>`
+	}
+	link := func(anchor, text string) string {
+		return `<ac:link ac:anchor="` + anchor + `"><ac:plain-text-link-body><![CDATA[` + text + `]]></ac:plain-text-link-body></ac:link>`
+	}
+	testRender(t, []renderTest{
+		{
+			name:     "one",
+			markdown: "Hello[^1].\n\n[^1]: A *note*.\n",
+			wiki:     "Hello{anchor:fnref-1}^[1|#fn-1]^.\n\n\n----\nh2. Notes\n# {anchor:fn-1}A _note_. [↩|#fnref-1]\n\n",
+			storage: "<p>Hello<sup>" + anchor("fnref-1") + link("fn-1", "1") + "</sup>.</p>\n<hr /><h2>Notes</h2>\n" +
+				"<ol><li>" + anchor("fn-1") + "A <em>note</em>. " + link("fnref-1", "↩") + "</li></ol>",
+		},
+		{
+			name:     "named",
+			markdown: "One[^a] two[^b].\n\n[^a]: First.\n[^b]: Second.\n",
+			wiki: "One{anchor:fnref-a}^[1|#fn-a]^ two{anchor:fnref-b}^[2|#fn-b]^.\n\n\n----\nh2. Notes\n" +
+				"# {anchor:fn-a}First. [↩|#fnref-a]\n# {anchor:fn-b}Second. [↩|#fnref-b]\n\n",
+			storage: "<p>One<sup>" + anchor("fnref-a") + link("fn-a", "1") + "</sup> two<sup>" + anchor("fnref-b") + link("fn-b", "2") + "</sup>.</p>\n" +
+				"<hr /><h2>Notes</h2>\n<ol><li>" + anchor("fn-a") + "First. " + link("fnref-a", "↩") + "</li>" +
+				"<li>" + anchor("fn-b") + "Second. " + link("fnref-b", "↩") + "</li></ol>",
+		},
+	})
+}
+
+func TestDefinitionLists(t *testing.T) {
+	terms := "Term 1\n: Def *one*\n\nTerm 2\n: Def two\n: Def three\n"
+	paragraphs := "Term\n\n: Para one\n\n    Para two\n"
+	testRender(t, []renderTest{
+		{
+			name:     "terms",
+			markdown: terms,
+			wiki:     "*Term 1*\n* Def _one_\n*Term 2*\n* Def two\n* Def three\n\n",
+			storage:  "<dl><dt>Term 1</dt><dd>Def <em>one</em></dd><dt>Term 2</dt><dd>Def two</dd><dd>Def three</dd></dl>",
+		},
+		{
+			name:     "terms in table",
+			markdown: terms,
+			flags:    DefinitionTables,
+			wiki:     "||Term 1|Def _one_|\n||Term 2|Def two \\\\ Def three|\n\n",
+			storage:  "<dl><dt>Term 1</dt><dd>Def <em>one</em></dd><dt>Term 2</dt><dd>Def two</dd><dd>Def three</dd></dl>",
+		},
+		{
+			name:     "paragraphs",
+			markdown: paragraphs,
+			wiki:     "*Term*\n* Para one\nPara two\n\n",
+			storage:  "<dl><dt>Term</dt><dd><p>Para one</p>\n<p>Para two</p>\n</dd></dl>",
+		},
+		{
+			name:     "paragraphs in table",
+			markdown: paragraphs,
+			flags:    DefinitionTables,
+			wiki:     "||Term|Para one \\\\ Para two|\n\n",
+			storage:  "<dl><dt>Term</dt><dd><p>Para one</p>\n<p>Para two</p>\n</dd></dl>",
+		},
+	})
+}
+
+func TestTables(t *testing.T) {
+	testRender(t, []renderTest{
+		{
+			name:     "pipes and line breaks",
+			markdown: "| A | B |\n|---|---|\n| a\\|b | line<br>two |\n",
+			wiki:     "||A||B||\n|a\\|b|line\\\\two|\n\n",
+			storage:  "<table><tbody><tr><th>A</th><th>B</th></tr><tr><td>a|b</td><td>line<br />two</td></tr></tbody></table>\n",
+		},
+		{
+			name:     "alignment",
+			markdown: "| A | B | C |\n|:--|:-:|--:|\n| *a* | b<br/>c | `d` |\n",
+			wiki:     "||A||B||C||\n|_a_|b\\\\c|{{d}}|\n\n",
+			storage: `<table><tbody><tr><th style="text-align: left;">A</th><th style="text-align: center;">B</th><th style="text-align: right;">C</th></tr>` +
+				`<tr><td style="text-align: left;"><em>a</em></td><td style="text-align: center;">b<br />c</td><td style="text-align: right;"><code>d</code></td></tr></tbody></table>` + "\n",
+		},
+		{
+			name:     "HTML",
+			markdown: "| A | B |\n|---|---|\n| x<img src=\"a.png\"> | <span title='t'>*s*</span><!-- c --> |\n",
+			wiki:     "||A||B||\n|x<img src=\"a.png\">|<span title='t'>_s_</span><!-- c -->|\n\n",
+			storage: `<table><tbody><tr><th>A</th><th>B</th></tr><tr><td>x<img src="a.png" /></td>` +
+				`<td><span title='t'><em>s</em></span><!-- c --></td></tr></tbody></table>` + "\n",
+		},
+		{
+			name:     "malformed HTML",
+			markdown: "| A | B | C |\n|---|---|---|\n| <img src=a.png> | <b>*x</b>* | <a href=\"?a=1&b=2\">l</a> |\n",
+			wiki:     "||A||B||C||\n|<img src=a.png>|<b>_x</b>_|<a href=\"?a=1&b=2\">l</a>|\n\n",
+			storage: `<table><tbody><tr><th>A</th><th>B</th><th>C</th></tr><tr><td>&lt;img src=a.png&gt;</td>` +
+				`<td>&lt;b&gt;<em>x&lt;/b&gt;</em></td><td>&lt;a href=&#34;?a=1&amp;b=2&#34;&gt;l&lt;/a&gt;</td></tr></tbody></table>` + "\n",
+		},
+	})
+}
+
+func TestNeedsStorageFormat(t *testing.T) {
+	for _, tc := range []struct {
+		markdown string
+		want     bool
+	}{
+		{"| A |\n|---|\n| a |\n", false},
+		{"| A |\n|:--|\n| a<br>b |\n", false},
+		{"| A |\n|:-:|\n| a |\n", true},
+		{"| A |\n|--:|\n| a |\n", true},
+		{"| A |\n|---|\n| <span>a</span> |\n", true},
+		{"Text <span>a</span>\n", false},
+		{"| A |\n|---|\n| x<img src=\"a.png\"> |\n", true},
+		{"| A |\n|---|\n| <span>a |\n", false},
+		{"| A |\n|---|\n| <img src=a.png> |\n", false},
+	} {
+		if got := NeedsStorageFormat(parse(tc.markdown)); got != tc.want {
+			t.Errorf("NeedsStorageFormat(%q) = %v, want %v", tc.markdown, got, tc.want)
+		}
+	}
+}
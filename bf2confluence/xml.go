@@ -1,8 +1,13 @@
 package bf2confluence
 
 import (
+	"bytes"
 	"fmt"
+	"html"
 	"io"
+	"regexp"
+	"sort"
+	"strings"
 
 	bf "github.com/russross/blackfriday/v2"
 )
@@ -13,9 +18,18 @@ type XmlRenderer struct {
 	inTableHeader bool
 }
 
+// reEntity matches the text of HTML entities, which the parser keeps in nodes
+// of their own. Unknown ones are written as they are, escaped.
+var reEntity = regexp.MustCompile(`^&(?:#[0-9]+|#[xX][0-9a-fA-F]+|[a-zA-Z][a-zA-Z0-9]*);$`)
+
+// esc writes text with XML special characters escaped.
+func (r *XmlRenderer) esc(w io.Writer, text []byte) {
+	r.out(w, []byte(html.EscapeString(string(text))))
+}
+
 func (r *XmlRenderer) cdata(w io.Writer, content []byte) {
 	w.Write([]byte("<![CDATA["))
-	w.Write(content)
+	w.Write(bytes.ReplaceAll(content, []byte("]]>"), []byte("]]]]><![CDATA[>")))
 	w.Write([]byte("]]>"))
 }
 
@@ -58,11 +72,106 @@ func (r *XmlRenderer) closeStructuredMacro(w io.Writer) {
 	r.closeTag(w, []byte(`ac:structured-macro`))
 }
 
+func (r *XmlRenderer) anchor(w io.Writer, name string) {
+	r.openStructuredMacro(w, "anchor")
+	r.openParameter(w, "")
+	r.esc(w, []byte(name))
+	r.closeParameter(w)
+	r.closeStructuredMacro(w)
+}
+
+// anchorLink writes a link to an anchor on the same page.
+func (r *XmlRenderer) anchorLink(w io.Writer, name, text string) {
+	r.openTag(w, []byte(fmt.Sprintf(`ac:link ac:anchor="%s"`, html.EscapeString(name))))
+	r.openTag(w, []byte("ac:plain-text-link-body"))
+	r.cdata(w, []byte(text))
+	r.closeTag(w, []byte("ac:plain-text-link-body"))
+	r.closeTag(w, []byte("ac:link"))
+}
+
+// pageMacros are macros of which the default parameter is a page, given as
+// "Title" or "SPACE:Title".
+var pageMacros = map[string]bool{
+	"excerpt-include": true,
+	"include":         true,
+}
+
+func (r *XmlRenderer) pageLink(w io.Writer, page string) {
+	attrs := ""
+	if i := strings.IndexByte(page, ':'); i > 0 {
+		attrs = fmt.Sprintf(` ri:space-key="%s"`, html.EscapeString(page[:i]))
+		page = page[i+1:]
+	}
+	r.openTag(w, []byte("ac:link"))
+	r.out(w, []byte(fmt.Sprintf(`<ri:page%s ri:content-title="%s" />`, attrs, html.EscapeString(page))))
+	r.closeTag(w, []byte("ac:link"))
+}
+
+// paragraphs writes plain text as paragraphs separated by blank lines, with
+// lines broken as in wiki markup.
+func (r *XmlRenderer) paragraphs(w io.Writer, text []byte) {
+	for _, para := range reBlankLines.Split(strings.TrimSpace(string(text)), -1) {
+		r.openTag(w, []byte("p"))
+		for i, line := range strings.Split(para, "\n") {
+			if i > 0 {
+				r.out(w, []byte(`<br />`))
+			}
+			r.esc(w, []byte(line))
+		}
+		r.closeTag(w, []byte("p"))
+	}
+}
+
+// details writes the details block as an expand macro.
+func (r *XmlRenderer) details(w io.Writer, d *details) {
+	render := func(node *bf.Node, entering bool) bf.WalkStatus {
+		return r.RenderNode(w, node, entering)
+	}
+	inline := func(nodes []*bf.Node) {
+		if blank(nodes) {
+			return
+		}
+		r.openTag(w, []byte("p"))
+		for _, n := range nodes {
+			n.Walk(render)
+		}
+		r.closeTag(w, []byte("p"))
+	}
+
+	r.openStructuredMacro(w, "expand")
+	if d.title != "" {
+		r.openParameter(w, "title")
+		r.esc(w, []byte(d.title))
+		r.closeParameter(w)
+	}
+	r.openTag(w, []byte("ac:rich-text-body"))
+	inline(d.lead)
+	d.eachBlock(func(n *bf.Node) {
+		n.Walk(render)
+	})
+	inline(d.trail)
+	r.closeTag(w, []byte("ac:rich-text-body"))
+	r.closeStructuredMacro(w)
+	r.cr(w)
+}
+
 // RenderNode is a bf2confluence renderer of a single node of a syntax tree.
 func (r *XmlRenderer) RenderNode(w io.Writer, node *bf.Node, entering bool) bf.WalkStatus {
+	if r.skipTo != nil {
+		if node == r.skipTo {
+			r.skipTo = nil
+		}
+		return bf.SkipChildren
+	}
+
 	switch node.Type {
 	case bf.Text:
-		r.out(w, node.Literal)
+		if reEntity.Match(node.Literal) {
+			// Storage format is XML, which defines few of the HTML entities
+			r.esc(w, []byte(html.UnescapeString(string(node.Literal))))
+		} else {
+			r.esc(w, node.Literal)
+		}
 	case bf.Softbreak:
 		break
 	case bf.Hardbreak:
@@ -74,24 +183,41 @@ func (r *XmlRenderer) RenderNode(w io.Writer, node *bf.Node, entering bool) bf.W
 			r.closeTag(w, []byte("blockquote"))
 		}
 	case bf.CodeBlock:
-		if len(node.Info) > 0 {
-			language := string(node.Info)
-			r.openStructuredMacro(w, "code")
-			r.openParameter(w, "")
-			r.out(w, []byte(language))
-			r.closeParameter(w)
-			r.openTag(w, []byte("ac:plain-text-body"))
-			r.cdata(w, node.Literal)
-			r.closeTag(w, []byte("ac:plain-text-body"))
+		info := ParseCodeInfo(node.Info)
+		if _, ok := informationMacros[info.Language]; ok && r.Flags&InformationMacros != 0 {
+			r.openStructuredMacro(w, info.Language)
+			if title, ok := info.Attributes["title"]; ok {
+				r.openParameter(w, "title")
+				r.esc(w, []byte(title))
+				r.closeParameter(w)
+			}
+			r.openTag(w, []byte("ac:rich-text-body"))
+			r.paragraphs(w, node.Literal)
+			r.closeTag(w, []byte("ac:rich-text-body"))
 			r.closeStructuredMacro(w)
 			r.cr(w)
+			break
 		}
-	case bf.Code:
-		if entering {
-			r.openTag(w, []byte("code"))
-		} else {
-			r.closeTag(w, []byte("code"))
+		r.openStructuredMacro(w, "code")
+		if info.Language != "" {
+			r.openParameter(w, "language")
+			r.esc(w, []byte(info.Language))
+			r.closeParameter(w)
+		}
+		for _, p := range info.parameters() {
+			r.openParameter(w, p[0])
+			r.esc(w, []byte(p[1]))
+			r.closeParameter(w)
 		}
+		r.openTag(w, []byte("ac:plain-text-body"))
+		r.cdata(w, node.Literal)
+		r.closeTag(w, []byte("ac:plain-text-body"))
+		r.closeStructuredMacro(w)
+		r.cr(w)
+	case bf.Code:
+		r.openTag(w, []byte("code"))
+		r.esc(w, node.Literal)
+		r.closeTag(w, []byte("code"))
 	case bf.Emph:
 		if entering {
 			r.openTag(w, []byte("em"))
@@ -102,27 +228,67 @@ func (r *XmlRenderer) RenderNode(w io.Writer, node *bf.Node, entering bool) bf.W
 		headingTag := []byte(fmt.Sprintf("h%d", node.Level))
 		if entering {
 			r.openTag(w, headingTag)
+			if r.Flags&HeadingAnchors != 0 && node.HeadingID != "" {
+				r.anchor(w, node.HeadingID)
+			}
 		} else {
 			r.closeTag(w, headingTag)
 			r.cr(w)
 		}
 	case bf.Image:
-		if entering {
-			r.openTag(w, []byte(`ac:image href="%s"`))
-			r.openTag(w, []byte(fmt.Sprintf(`ri:url ri:value="%s"`, node.LinkData.Destination)))
-			r.closeTag(w, []byte("ri:url"))
+		// Written on entering, as the description is an attribute
+		if alt := altText(node); strings.TrimSpace(alt) != "" {
+			r.openTag(w, []byte(fmt.Sprintf(`ac:image ac:alt="%s"`, html.EscapeString(alt))))
 		} else {
-			r.closeTag(w, []byte("ac:image"))
+			r.openTag(w, []byte("ac:image"))
 		}
+		if name := attachmentName(node.LinkData.Destination); name != "" {
+			r.out(w, []byte(fmt.Sprintf(`<ri:attachment ri:filename="%s" />`, html.EscapeString(name))))
+		} else {
+			r.out(w, []byte(fmt.Sprintf(`<ri:url ri:value="%s" />`, html.EscapeString(string(node.LinkData.Destination)))))
+		}
+		r.closeTag(w, []byte("ac:image"))
+		return bf.SkipChildren
 	case bf.Item:
+		itemTag := []byte("li")
+		if node.ListFlags&bf.ListTypeTerm != 0 {
+			itemTag = []byte("dt")
+		} else if node.ListFlags&bf.ListTypeDefinition != 0 {
+			itemTag = []byte("dd")
+		}
 		if entering {
-			r.openTag(w, []byte("li"))
+			r.openTag(w, itemTag)
+			if node.RefLink != nil {
+				r.anchor(w, footnoteID(node.RefLink))
+			}
 		} else {
-			r.closeTag(w, []byte("li"))
+			if node.RefLink != nil {
+				r.out(w, spaceBytes)
+				r.anchorLink(w, footnoteRefID(node.RefLink), footnoteBackLink)
+			}
+			r.closeTag(w, itemTag)
 		}
 	case bf.Link:
-		if entering {
-			r.openTag(w, []byte(fmt.Sprintf(`a href="%s"`, node.LinkData.Destination)))
+		if node.NoteID != 0 {
+			// Footnote reference, which has no children
+			if entering {
+				r.openTag(w, []byte("sup"))
+				r.anchor(w, footnoteRefID(node.Destination))
+				r.anchorLink(w, footnoteID(node.Destination), fmt.Sprint(node.NoteID))
+				r.closeTag(w, []byte("sup"))
+			}
+			break
+		}
+		// Links to #fragment refer to anchors on the same page
+		anchor := bytes.HasPrefix(node.LinkData.Destination, []byte("#"))
+		if entering && anchor {
+			r.openTag(w, []byte(fmt.Sprintf(`ac:link ac:anchor="%s"`, html.EscapeString(string(node.LinkData.Destination[1:])))))
+			r.openTag(w, []byte("ac:link-body"))
+		} else if entering {
+			r.openTag(w, []byte(fmt.Sprintf(`a href="%s"`, html.EscapeString(string(node.LinkData.Destination)))))
+		} else if anchor {
+			r.closeTag(w, []byte("ac:link-body"))
+			r.closeTag(w, []byte("ac:link"))
 		} else {
 			r.closeTag(w, []byte("a"))
 		}
@@ -130,14 +296,35 @@ func (r *XmlRenderer) RenderNode(w io.Writer, node *bf.Node, entering bool) bf.W
 		r.out(w, []byte(`<hr />`))
 		r.cr(w)
 	case bf.List:
+		listTag := []byte("ul")
+		if node.ListFlags&bf.ListTypeOrdered != 0 {
+			listTag = []byte("ol")
+		} else if node.ListFlags&bf.ListTypeDefinition != 0 {
+			listTag = []byte("dl")
+		}
+		if entering && node.IsFootnotesList {
+			r.out(w, []byte(`<hr />`))
+			r.tag(w, []byte("h2"), []byte(footnotesTitle))
+			r.cr(w)
+		}
 		if entering {
-			r.openTag(w, []byte("ul"))
+			r.openTag(w, listTag)
 		} else {
-			r.closeTag(w, []byte("ul"))
+			r.closeTag(w, listTag)
 		}
 	case bf.Document:
 		break
 	case bf.Paragraph:
+		if d := parseDetails(node); d != nil && entering {
+			r.details(w, d)
+			if d.end != node {
+				r.skipTo = d.end
+			}
+			return bf.SkipChildren
+		}
+		if node.Parent.ListFlags&bf.ListTypeDefinition != 0 && node.Prev == nil && node.Next == nil {
+			break // a term or definition of a single paragraph
+		}
 		if entering {
 			r.openTag(w, []byte("p"))
 		} else {
@@ -163,20 +350,25 @@ func (r *XmlRenderer) RenderNode(w io.Writer, node *bf.Node, entering bool) bf.W
 		} else {
 			r.closeTag(w, []byte("tbody"))
 			r.closeTag(w, []byte("table"))
+			r.cr(w)
 		}
 	case bf.TableCell:
+		cellTag := "td"
+		if r.inTableHeader {
+			cellTag = "th"
+		}
 		if entering {
-			if r.inTableHeader {
-				r.openTag(w, []byte("th"))
-			} else {
-				r.openTag(w, []byte("td"))
+			switch node.Align {
+			case bf.TableAlignmentLeft:
+				cellTag += ` style="text-align: left;"`
+			case bf.TableAlignmentRight:
+				cellTag += ` style="text-align: right;"`
+			case bf.TableAlignmentCenter:
+				cellTag += ` style="text-align: center;"`
 			}
+			r.openTag(w, []byte(cellTag))
 		} else {
-			if r.inTableHeader {
-				r.closeTag(w, []byte("th"))
-			} else {
-				r.closeTag(w, []byte("td"))
-			}
+			r.closeTag(w, []byte(cellTag))
 		}
 	case bf.TableHead:
 		if entering {
@@ -192,21 +384,45 @@ func (r *XmlRenderer) RenderNode(w io.Writer, node *bf.Node, entering bool) bf.W
 			r.closeTag(w, []byte("tr"))
 		}
 	case bf.HTMLBlock:
-		if entering {
-			r.openTag(w, []byte("div"))
-		} else {
-			r.closeTag(w, []byte("div"))
-		}
+		// Shown as text, the same as in wiki markup
+		r.paragraphs(w, node.Literal)
+		r.cr(w)
 	case bf.HTMLSpan:
-		r.out(w, node.Literal)
+		switch {
+		case lineBreak.Match(node.Literal):
+			r.out(w, []byte(`<br />`))
+		case wellFormedHTML(node):
+			r.out(w, closeVoidElement(node.Literal))
+		default:
+			r.esc(w, node.Literal)
+		}
 	case bf.Macro:
+		if !entering {
+			r.closeTag(w, []byte("ac:rich-text-body"))
+			r.closeStructuredMacro(w)
+			r.cr(w)
+			break
+		}
 		r.openStructuredMacro(w, node.Name)
-		for param, value := range node.Parameters {
+		var params []string
+		for param := range node.Parameters {
+			params = append(params, param)
+		}
+		sort.Strings(params)
+		for _, param := range params {
 			r.openParameter(w, param)
-			r.cdata(w, []byte(value))
+			if param == "" && pageMacros[node.Name] {
+				r.pageLink(w, node.Parameters[param])
+			} else {
+				r.cdata(w, []byte(node.Parameters[param]))
+			}
 			r.closeParameter(w)
 		}
-		r.closeStructuredMacro(w)
+		if node.FirstChild != nil {
+			r.openTag(w, []byte("ac:rich-text-body"))
+		} else {
+			r.closeStructuredMacro(w)
+		}
 	default:
 		panic("Unknown node type " + node.Type.String())
 	}
@@ -215,9 +431,11 @@ func (r *XmlRenderer) RenderNode(w io.Writer, node *bf.Node, entering bool) bf.W
 
 // Render prints out the whole document from the ast.
 func (r *XmlRenderer) Render(ast *bf.Node) []byte {
+	r.RenderHeader(&r.w, ast)
 	ast.Walk(func(node *bf.Node, entering bool) bf.WalkStatus {
 		return r.RenderNode(&r.w, node, entering)
 	})
+	r.RenderFooter(&r.w, ast)
 
 	return r.w.Bytes()
 }
@@ -0,0 +1,53 @@
+package bf2confluence
+
+import "testing"
+
+func renderStorage(markdown string, flags Flag) string {
+	r := &XmlRenderer{Renderer: Renderer{Flags: flags}}
+	return string(r.Render(parse(markdown)))
+}
+
+// TestRenderBothFormats checks that a document renders to the same content in
+// wiki markup and storage format, which pages are switched to for tables.
+func TestRenderBothFormats(t *testing.T) {
+	markdown := "![An image](img/test.png) ![](http://example.com/a.png?x=1&y=2)\n\n" +
+		"```warning title=Careful\nDon't <break>\nthis\n\nReally\n```\n\n" +
+		"<div class=\"x\">\n<b>bold</b>\n</div>\n\n" +
+		"| A | B |\n|:--|--:|\n| 1 | 2 |\n"
+
+	wantWiki := "!test.png|alt=An image! !http://example.com/a.png?x=1&y=2!\n\n" +
+		"{warning:title=Careful}\nDon't <break>\nthis\n\nReally\n{warning}\n\n" +
+		"<div class=\"x\">\n<b>bold</b>\n</div>\n\n" +
+		"||A||B||\n|1|2|\n\n"
+	wantStorage := `<p><ac:image ac:alt="An image"><ri:attachment ri:filename="test.png" /></ac:image> ` +
+		`<ac:image><ri:url ri:value="http://example.com/a.png?x=1&amp;y=2" /></ac:image></p>` + "\n" +
+		`<ac:structured-macro ac:name="warning"><ac:parameter ac:name="title">Careful</ac:parameter><ac:rich-text-body>` +
+		`<p>Don&#39;t &lt;break&gt;<br />this</p><p>Really</p></ac:rich-text-body></ac:structured-macro>` + "\n" +
+		`<p>&lt;div class=&#34;x&#34;&gt;<br />&lt;b&gt;bold&lt;/b&gt;<br />&lt;/div&gt;</p>` + "\n" +
+		`<table><tbody><tr><th style="text-align: left;">A</th><th style="text-align: right;">B</th></tr>` +
+		`<tr><td style="text-align: left;">1</td><td style="text-align: right;">2</td></tr></tbody></table>` + "\n"
+
+	if got := renderWiki(markdown, InformationMacros); got != wantWiki {
+		t.Errorf("wiki:\n got %q\nwant %q", got, wantWiki)
+	}
+	if got := renderStorage(markdown, InformationMacros); got != wantStorage {
+		t.Errorf("storage:\n got %q\nwant %q", got, wantStorage)
+	}
+}
+
+func TestEntities(t *testing.T) {
+	testRender(t, []renderTest{
+		{
+			name:     "entities",
+			markdown: "a&nbsp;b &copy; &#169; &#x263A; &amp; &bogus; AT&T\n",
+			wiki:     "a&nbsp;b &copy; &#169; &#x263A; & &bogus; AT&T\n\n",
+			storage:  "<p>a\u00a0b \u00a9 \u00a9 \u263a &amp; &amp;bogus; AT&amp;T</p>\n",
+		},
+		{
+			name:     "escaped and in code",
+			markdown: "\\&copy; `&copy;`\n",
+			wiki:     "&copy; {{&copy;}}\n\n",
+			storage:  "<p>&amp;copy; <code>&amp;copy;</code></p>\n",
+		},
+	})
+}
@@ -110,6 +110,8 @@ type HeadingData struct {
 	IsTitleblock bool   // Specifies whether it'cd s a title block
 }
 
+// MacroData contains fields relevant to a Macro node type. Macros having a
+// body hold it as children.
 type MacroData struct {
 	Name       string
 	Parameters map[string]string
@@ -241,6 +243,8 @@ func (n *Node) IsContainer() bool {
 		fallthrough
 	case TableCell:
 		return true
+	case Macro:
+		return n.FirstChild != nil // with a body, e.g. {excerpt}...{excerpt}
 	default:
 		return false
 	}
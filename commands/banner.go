@@ -0,0 +1,117 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/russross/blackfriday/v2"
+)
+
+// bannerData is passed to header and footer templates, e.g.
+// "This page is generated from {{.Path}} at {{.ShortCommit}}".
+type bannerData struct {
+	gitInfo           // Zero if the file is not in a git repository
+	File    string    // Path of the markdown file as given
+	Title   string    // Title of the page
+	Time    time.Time // Time of publishing
+}
+
+// banner is an expanded header or footer template. Templates named *.md or
+// *.markdown are parsed into nodes, others are raw wiki markup or storage
+// format depending on the page.
+type banner struct {
+	nodes []*blackfriday.Node
+	raw   []byte
+}
+
+func loadBanner(filename string, data *bannerData) (*banner, error) {
+	text, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	t, err := template.New(filepath.Base(filename)).Parse(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("invalid banner template: %v", err)
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return nil, fmt.Errorf("invalid banner template: %v", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".md", ".markdown":
+		var nodes []*blackfriday.Node
+		doc := blackfriday.New(blackfriday.WithExtensions(markdownExtensions)).Parse(b.Bytes())
+		for n := doc.FirstChild; n != nil; n = n.Next {
+			nodes = append(nodes, n)
+		}
+		return &banner{nodes: nodes}, nil
+	default:
+		return &banner{raw: b.Bytes()}, nil
+	}
+}
+
+// bannerFile returns the header or footer template of the file. The key in
+// front matter may be false to disable it, or the path of another template
+// relative to dir, the directory of the markdown file.
+func (pf *parsedMarkdown) bannerFile(key, def, dir string) string {
+	v, ok := pf.confluenceValue(key)
+	if !ok {
+		return def
+	}
+	switch v := v.(type) {
+	case bool:
+		if !v {
+			return ""
+		}
+	case string:
+		return filepath.Join(dir, v)
+	}
+	return def
+}
+
+// addBanners injects the header and footer templates around the document.
+func (c *uploadCmd) addBanners(pmd *parsedMarkdown, filename string, git *gitInfo) error {
+	data := bannerData{
+		File:  filepath.ToSlash(filename),
+		Title: pmd.Title(c.title),
+		Time:  time.Now(),
+	}
+	if git != nil {
+		data.gitInfo = *git
+	} else {
+		data.Path = data.File
+	}
+	dir := filepath.Dir(filename)
+
+	if f := pmd.bannerFile("header", c.header, dir); f != "" {
+		header, err := loadBanner(f, &data)
+		if err != nil {
+			return err
+		}
+		for i := len(header.nodes) - 1; i >= 0; i-- {
+			if first := pmd.contentAst.FirstChild; first != nil {
+				first.InsertBefore(header.nodes[i])
+			} else {
+				pmd.contentAst.AppendChild(header.nodes[i])
+			}
+		}
+		pmd.header = header.raw
+	}
+	if f := pmd.bannerFile("footer", c.footer, dir); f != "" {
+		footer, err := loadBanner(f, &data)
+		if err != nil {
+			return err
+		}
+		for _, n := range footer.nodes {
+			pmd.contentAst.AppendChild(n)
+		}
+		pmd.footer = footer.raw
+	}
+	return nil
+}
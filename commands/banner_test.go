@@ -0,0 +1,97 @@
+package commands
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAddBanners(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		markdown string
+		header   string // --header, relative to the document
+		footer   string // --footer, relative to the document
+		want     string
+	}{
+		{
+			name:     "flags",
+			markdown: "---\ntitle: Page\n---\nHello\n",
+			header:   "header.md",
+			footer:   "footer.wiki",
+			want:     "*Generated from {{.File}}*\n\nHello\n\n{note}Edits to Page will be overwritten{note}\n",
+		},
+		{
+			name:     "disabled in front matter",
+			markdown: "---\nconfluence:\n  header: false\n  footer: false\n---\nHello\n",
+			header:   "header.md",
+			footer:   "footer.wiki",
+			want:     "Hello\n\n",
+		},
+		{
+			name:     "replaced in front matter",
+			markdown: "---\nconfluence:\n  header: other.md\n---\nHello\n",
+			header:   "header.md",
+			want:     "_Other_\n\nHello\n\n",
+		},
+		{
+			name:     "front matter only",
+			markdown: "---\ntitle: Page\nconfluence:\n  footer: footer.wiki\n---\nHello\n",
+			want:     "Hello\n\n{note}Edits to Page will be overwritten{note}\n",
+		},
+	} {
+		md := writeTestFiles(t, tc.markdown)
+		dir := filepath.Dir(md)
+		writeBanners(t, dir)
+
+		c := &uploadCmd{}
+		if tc.header != "" {
+			c.header = filepath.Join(dir, tc.header)
+		}
+		if tc.footer != "" {
+			c.footer = filepath.Join(dir, tc.footer)
+		}
+		var pmd parsedMarkdown
+		if err := pmd.parse(md); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.addBanners(&pmd, md, nil); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		want := strings.Replace(tc.want, "{{.File}}", filepath.ToSlash(md), 1)
+		if got := string(pmd.render(pmd.format())); got != want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, want)
+		}
+	}
+}
+
+func TestAddBannersInvalidTemplate(t *testing.T) {
+	md := writeTestFiles(t, "---\ntitle: Page\n---\nHello\n")
+	header := filepath.Join(filepath.Dir(md), "header.md")
+	if err := ioutil.WriteFile(header, []byte("{{.Nope}}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var pmd parsedMarkdown
+	if err := pmd.parse(md); err != nil {
+		t.Fatal(err)
+	}
+	c := &uploadCmd{header: header}
+	if err := c.addBanners(&pmd, md, nil); err == nil || !strings.Contains(err.Error(), "invalid banner template") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+// writeBanners writes header and footer templates into dir.
+func writeBanners(t *testing.T, dir string) {
+	for name, text := range map[string]string{
+		"header.md":   "**Generated from {{.Path}}**\n",
+		"footer.wiki": "{note}Edits to {{.Title}} will be overwritten{note}\n",
+		"other.md":    "*Other*\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -1,6 +1,12 @@
 package commands
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"github.com/spf13/cobra"
 )
 
@@ -11,6 +17,17 @@ var rootCmd = struct {
 	password           string
 	saveCredential     bool
 	useSavedCredential bool
+	timeout            time.Duration
+	retries            int
+	caCert             string
+	clientCert         string
+	clientKey          string
+	proxy              string
+	noProxy            string
+	insecureSkipVerify bool
+
+	// ctx is canceled on SIGINT/SIGTERM so in-flight requests stop cleanly.
+	ctx context.Context
 }{
 	Command: &cobra.Command{
 		Use:   "md2cfl",
@@ -18,13 +35,45 @@ var rootCmd = struct {
 	},
 }
 
-func Execute() error {
+func init() {
 	rootCmd.PersistentFlags().StringVarP(&rootCmd.baseUrl, "base", "b", "", "Confluence base URL")
 	rootCmd.PersistentFlags().StringVarP(&rootCmd.userName, "user", "u", "", "Confluence user name")
 	rootCmd.PersistentFlags().StringVarP(&rootCmd.password, "password", "p", "", "Confluence password")
 	rootCmd.PersistentFlags().BoolVarP(&rootCmd.saveCredential, "save-credential", "s", false, "Save username and password to system credential store")
 	rootCmd.PersistentFlags().BoolVar(&rootCmd.useSavedCredential, "use-saved-credential", true, "Use saved credential")
+	rootCmd.PersistentFlags().DurationVar(&rootCmd.timeout, "timeout", time.Minute, "Timeout of connecting to Confluence and of waiting for each response, not limiting uploads (0 means no timeout)")
+	rootCmd.PersistentFlags().IntVar(&rootCmd.retries, "retries", 2, "Times to retry a request failed by rate limiting, or reads failed by network errors or an unavailable server")
+	rootCmd.PersistentFlags().StringVar(&rootCmd.caCert, "ca-cert", "", "PEM file of additional CA certificates to trust (default from MD2CFL_CA_CERT)")
+	rootCmd.PersistentFlags().StringVar(&rootCmd.clientCert, "client-cert", "", "PEM file of client certificate for mutual TLS (default from MD2CFL_CLIENT_CERT)")
+	rootCmd.PersistentFlags().StringVar(&rootCmd.clientKey, "client-key", "", "PEM file of client private key for mutual TLS (default from MD2CFL_CLIENT_KEY)")
+	rootCmd.PersistentFlags().StringVar(&rootCmd.proxy, "proxy", "", "HTTP proxy URL (default from MD2CFL_PROXY, or HTTP_PROXY/HTTPS_PROXY)")
+	rootCmd.PersistentFlags().StringVar(&rootCmd.noProxy, "no-proxy", "", "Comma separated hosts to connect to directly, bypassing --proxy or the proxy from HTTP_PROXY/HTTPS_PROXY (default from NO_PROXY)")
+	rootCmd.PersistentFlags().BoolVar(&rootCmd.insecureSkipVerify, "insecure-skip-verify", false, "Don't verify server certificate (insecure)")
 	rootCmd.AddCommand(newUploadCmd())
+	rootCmd.AddCommand(newFakeServerCmd())
+}
+
+func Execute() error {
+	ctx, cancel := newSignalContext()
+	defer cancel()
+	rootCmd.ctx = ctx
 
 	return rootCmd.Execute()
 }
+
+// newSignalContext returns a context that is canceled on the first interrupt
+// or termination signal.
+func newSignalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-ch:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(ch)
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,209 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/p47t/md2cfl/bf2confluence"
+	"github.com/russross/blackfriday/v2"
+)
+
+var (
+	// {{< excerpt-include "Page Title" nopanel=true >}}
+	reExcerptInclude = regexp.MustCompile(`^\{\{<\s*excerpt-include\s+(.*?)\s*>\}\}$`)
+
+	summaryDivider = []byte("<!--more-->")
+)
+
+// addExcerpt wraps the summary of the page in an excerpt macro, to be shown by
+// excerpt-include macros of other pages. The summary is either "summary" in
+// front matter, which is added as a hidden excerpt, or the content before
+// <!--more-->.
+func (pf *parsedMarkdown) addExcerpt() {
+	divider := pf.findSummaryDivider()
+	if summary, ok := pf.frontMatter["summary"].(string); ok && strings.TrimSpace(summary) != "" {
+		if divider != nil {
+			divider.Unlink()
+		}
+		excerpt := newMacro("excerpt", map[string]string{"hidden": "true"})
+		doc := blackfriday.New(blackfriday.WithExtensions(markdownExtensions)).Parse([]byte(summary))
+		for n := doc.FirstChild; n != nil; n = doc.FirstChild {
+			excerpt.AppendChild(n)
+		}
+		if first := pf.contentAst.FirstChild; first != nil {
+			first.InsertBefore(excerpt)
+		} else {
+			pf.contentAst.AppendChild(excerpt)
+		}
+		return
+	}
+	if divider == nil || divider.Prev == nil {
+		if divider != nil {
+			divider.Unlink()
+		}
+		return
+	}
+
+	excerpt := newMacro("excerpt", nil)
+	for n := pf.contentAst.FirstChild; n != divider; n = pf.contentAst.FirstChild {
+		excerpt.AppendChild(n)
+	}
+	divider.InsertBefore(excerpt)
+	divider.Unlink()
+}
+
+// findSummaryDivider returns the top level node of <!--more--> if the page
+// has one. A paragraph having the divider inline is split at it.
+func (pf *parsedMarkdown) findSummaryDivider() *blackfriday.Node {
+	if !pf.summaryDivider {
+		return nil
+	}
+	for n := pf.contentAst.FirstChild; n != nil; n = n.Next {
+		switch n.Type {
+		case blackfriday.HTMLBlock:
+			if bytes.Equal(bytes.TrimSpace(n.Literal), summaryDivider) {
+				return n
+			}
+		case blackfriday.Paragraph:
+			span := n.FirstChild
+			for span != nil && !(span.Type == blackfriday.HTMLSpan && bytes.Equal(span.Literal, summaryDivider)) {
+				span = span.Next
+			}
+			if span == nil {
+				continue
+			}
+			rest := blackfriday.NewNode(blackfriday.Paragraph)
+			for c := span.Next; c != nil; c = span.Next {
+				rest.AppendChild(c)
+			}
+			span.Unlink()
+
+			divider := blackfriday.NewNode(blackfriday.HTMLBlock)
+			divider.Literal = summaryDivider
+			insertAfter(n, divider)
+			if trimParagraph(rest) {
+				insertAfter(divider, rest)
+			}
+			if !trimParagraph(n) {
+				n.Unlink()
+			}
+			return divider
+		}
+	}
+	return nil
+}
+
+// trimParagraph removes white spaces around the text of a paragraph, and
+// reports whether anything is left.
+func trimParagraph(para *blackfriday.Node) bool {
+	if first := para.FirstChild; first != nil && first.Type == blackfriday.Text {
+		first.Literal = bytes.TrimLeft(first.Literal, " \t\n")
+	}
+	if last := para.LastChild; last != nil && last.Type == blackfriday.Text {
+		last.Literal = bytes.TrimRight(last.Literal, " \t\n")
+	}
+	for c := para.FirstChild; c != nil; c = c.Next {
+		if c.Type != blackfriday.Text || len(c.Literal) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func insertAfter(n, sibling *blackfriday.Node) {
+	if n.Next != nil {
+		n.Next.InsertBefore(sibling)
+	} else {
+		n.Parent.AppendChild(sibling)
+	}
+}
+
+// excerptIncludes replaces paragraphs of {{< excerpt-include "Page Title" >}}
+// with excerpt-include macros. The page may be given by its markdown file
+// relative to dir instead, or files matching a pattern such as "guides/*.md"
+// to include the excerpts of all of them ordered by title. Other attributes
+// are passed to the macro, e.g. nopanel=true. self, the real path of the
+// page, is never matched. It returns the markdown files the titles are read
+// from.
+func excerptIncludes(doc *blackfriday.Node, dir, self string) (files []string, err error) {
+	for n := doc.FirstChild; n != nil; n = n.Next {
+		if n.Type != blackfriday.Paragraph {
+			continue
+		}
+		m := reExcerptInclude.FindStringSubmatch(strings.TrimSpace(string(literalText(n))))
+		if m == nil {
+			continue
+		}
+		info := bf2confluence.ParseCodeInfo([]byte(m[1]))
+		page := strings.Trim(info.Language, `"'`)
+		if page == "" {
+			return nil, fmt.Errorf("excerpt-include without page")
+		}
+
+		titles := []string{page}
+		switch strings.ToLower(filepath.Ext(page)) {
+		case ".md", ".markdown":
+			matches, err := filepath.Glob(filepath.Join(dir, filepath.FromSlash(page)))
+			if err != nil {
+				return nil, fmt.Errorf("excerpt-include %s: %v", page, err)
+			}
+			for i := 0; i < len(matches); i++ {
+				if realPath(matches[i]) == self {
+					matches = append(matches[:i], matches[i+1:]...)
+					i--
+				}
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("excerpt-include %s: no such file", page)
+			}
+			if titles, err = pageTitles(matches); err != nil {
+				return nil, err
+			}
+			for _, f := range matches {
+				files = append(files, filepath.ToSlash(f))
+			}
+		}
+
+		for _, title := range titles {
+			params := map[string]string{"": title}
+			for k, v := range info.Attributes {
+				params[k] = v
+			}
+			n.InsertBefore(newMacroParagraph("excerpt-include", params))
+		}
+		prev := n.Prev
+		n.Unlink()
+		n = prev
+	}
+	return files, nil
+}
+
+// pageTitles returns the titles in front matter of markdown files, sorted.
+func pageTitles(files []string) ([]string, error) {
+	var titles []string
+	for _, f := range files {
+		var pmd parsedMarkdown
+		if err := pmd.parseFrontMatter(f); err != nil {
+			return nil, fmt.Errorf("excerpt-include %s: %v", f, err)
+		}
+		title := pmd.Title("")
+		if title == "" {
+			return nil, fmt.Errorf("excerpt-include %s: no title in front matter", f)
+		}
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+	return titles, nil
+}
+
+// newMacro returns a Confluence macro having a body, to which blocks are to
+// be appended.
+func newMacro(name string, params map[string]string) *blackfriday.Node {
+	macro := newMacroParagraph(name, params).FirstChild
+	macro.Unlink()
+	return macro
+}
@@ -0,0 +1,78 @@
+package commands
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAddExcerpt(t *testing.T) {
+	for _, tc := range []struct {
+		markdown string
+		want     string
+	}{
+		{"---\ntitle: Page\n---\nLead.\n\n<!--more-->\n\nRest.\n", "{excerpt}\nLead.\n\n{excerpt}\n\nRest.\n\n"},
+		{"---\ntitle: Page\n---\nLead. <!--more--> Rest.\n", "{excerpt}\nLead.\n\n{excerpt}\n\nRest.\n\n"},
+		{"---\ntitle: Page\n---\n<!--more-->\n\nRest.\n", "Rest.\n\n"},
+		{"---\ntitle: Page\n---\nNo divider.\n", "No divider.\n\n"},
+		{"---\ntitle: Page\nsummary: A *page*.\n---\nLead.\n<!--more-->\n", "{excerpt:hidden=true}\nA _page_.\n\n{excerpt}\n\nLead.\n\n"},
+		{"---\ntitle: Page\n---\n```html\n<!--more-->\n```\n", "{code:xml}\n<!--more-->\n{code}\n\n"},
+		{"---\ntitle: Page\nconfluence:\n  format: xml\n---\nLead.\n<!--more-->\n", `<ac:structured-macro ac:name="excerpt"><ac:rich-text-body><p>Lead.</p>` + "\n" +
+			"</ac:rich-text-body></ac:structured-macro>\n"},
+	} {
+		if got := renderTestFile(t, writeTestFiles(t, tc.markdown)); got != tc.want {
+			t.Errorf("got %q, want %q", got, tc.want)
+		}
+	}
+}
+
+func TestExcerptIncludes(t *testing.T) {
+	for _, tc := range []struct {
+		markdown string
+		want     string
+		err      string
+	}{
+		{
+			markdown: "{{< excerpt-include \"*.md\" nopanel=true >}}\n\n{{< excerpt-include \"OPS:Runbook\" >}}\n",
+			want:     "{excerpt-include:Deploy|nopanel=true}\n\n{excerpt-include:Install|nopanel=true}\n\n{excerpt-include:OPS:Runbook}\n\n",
+		},
+		{
+			markdown: "{{< excerpt-include \"install.md\" >}}\n",
+			want:     "{excerpt-include:Install}\n\n",
+		},
+		{
+			markdown: "{{< excerpt-include >}}\n",
+			err:      "excerpt-include without page",
+		},
+		{
+			markdown: "{{< excerpt-include \"guides/*.md\" >}}\n",
+			err:      "excerpt-include guides/*.md: no such file",
+		},
+		{
+			markdown: "{{< excerpt-include \"untitled.md\" >}}\n",
+			err:      "no title in front matter",
+		},
+	} {
+		md := writeTestFiles(t, "---\ntitle: Overview\n---\n"+tc.markdown)
+		dir := filepath.Dir(md)
+		for name, title := range map[string]string{"install.md": "Install", "deploy.md": "Deploy"} {
+			if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("---\ntitle: "+title+"\n---\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if tc.err != "" {
+			if err := ioutil.WriteFile(filepath.Join(dir, "untitled.md"), []byte("---\ntags: [a]\n---\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			var pmd parsedMarkdown
+			if err := pmd.parse(md); err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%q: unexpected error %v", tc.markdown, err)
+			}
+			continue
+		}
+		if got := renderTestFile(t, md); got != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.markdown, got, tc.want)
+		}
+	}
+}
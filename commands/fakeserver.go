@@ -0,0 +1,62 @@
+package commands
+
+import (
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/p47t/md2cfl/confluence/confluencetest"
+	"github.com/spf13/cobra"
+)
+
+type fakeServerCmd struct {
+	*cobra.Command
+	listen string
+	space  string
+	title  string
+}
+
+func newFakeServerCmd() *cobra.Command {
+	var c fakeServerCmd
+	c.Command = &cobra.Command{
+		Use:   "fake-server",
+		Short: "Run an in-memory fake Confluence server",
+		Long: `Run an in-memory fake Confluence server for local demos and offline development.
+
+A page is created on start and its ID is logged, e.g.:
+
+md2cfl fake-server &
+md2cfl -b http://localhost:8090 -u demo -p demo upload -P 10001 doc.md
+`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fake := confluencetest.NewFake()
+			pageId := fake.AddPage(c.space, c.title, "")
+
+			l, err := net.Listen("tcp", c.listen)
+			if err != nil {
+				return err
+			}
+			log.Println("Confluence Base:", "http://"+l.Addr().String())
+			log.Println("Confluence Page:", pageId)
+
+			srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				log.Println(r.Method, r.URL)
+				fake.ServeHTTP(w, r)
+			})}
+			go func() {
+				<-rootCmd.ctx.Done()
+				_ = srv.Close()
+			}()
+			if err := srv.Serve(l); err != http.ErrServerClosed {
+				return err
+			}
+			return nil
+		},
+	}
+	c.Command.Flags().StringVarP(&c.listen, "listen", "l", "localhost:8090", "address to listen on")
+	c.Command.Flags().StringVar(&c.space, "space", "DEMO", "space key of the initial page")
+	c.Command.Flags().StringVarP(&c.title, "title", "t", "Demo", "title of the initial page")
+
+	return c.Command
+}
@@ -0,0 +1,231 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/russross/blackfriday/v2"
+)
+
+// gitInfo describes the commit a markdown file is published from.
+type gitInfo struct {
+	Commit      string    // Full SHA of the last commit changing the file
+	ShortCommit string    // Abbreviated SHA of the last commit changing the file
+	Branch      string    // Current branch, empty if HEAD is detached
+	Author      string    // Author of the last commit changing the file
+	Date        time.Time // Commit date of the last commit changing the file
+	Path        string    // Path of the file relative to the repository root
+	Dirty       bool      // The file has uncommitted changes
+	URL         string    // Link to the file on the git host, if configured
+}
+
+func runGit(dir string, args ...string) (string, error) {
+	out, err := gitOutput(dir, args...)
+	return strings.TrimSpace(string(out)), err
+}
+
+func gitOutput(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	out, err := cmd.Output()
+	if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+		err = fmt.Errorf("git %s: %s", args[0], bytes.TrimSpace(ee.Stderr))
+	}
+	return out, err
+}
+
+// gitPaths returns the paths listed by a git command given -z, which are
+// neither quoted nor trimmed, unlike those separated by new lines.
+func gitPaths(dir string, args ...string) ([]string, error) {
+	out, err := gitOutput(dir, args...)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, name := range strings.Split(string(out), "\x00") {
+		if name != "" {
+			paths = append(paths, name)
+		}
+	}
+	return paths, nil
+}
+
+// realPath returns the absolute path of filename with symbolic links
+// resolved, so that paths from git and from the command line compare equal.
+func realPath(filename string) string {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return filename
+	}
+	if p, err := filepath.EvalSymlinks(abs); err == nil {
+		return p
+	}
+	return abs
+}
+
+// changedFiles returns paths of files in the repository at root which differ
+// from ref, including uncommitted and untracked ones.
+func changedFiles(root, ref string) ([]string, error) {
+	diff, err := gitPaths(root, "diff", "--name-only", "-z", ref, "--")
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := gitPaths(root, "ls-files", "-z", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, name := range append(diff, untracked...) {
+		files = append(files, filepath.Join(root, filepath.FromSlash(name)))
+	}
+	return files, nil
+}
+
+// filterChanged returns markdown files which themselves or their dependencies,
+// such as images and attachments, changed since ref.
+func filterChanged(files []string, ref string) ([]string, error) {
+	changed := make(map[string]bool)
+	roots := make(map[string]bool)
+	var result []string
+	for _, f := range files {
+		root, err := runGit(filepath.Dir(realPath(f)), "rev-parse", "--show-toplevel")
+		if err != nil {
+			return nil, fmt.Errorf("%s is not in a git repository", f)
+		}
+		if root = realPath(root); !roots[root] {
+			names, err := changedFiles(root, ref)
+			if err != nil {
+				return nil, err
+			}
+			for _, name := range names {
+				changed[name] = true
+			}
+			roots[root] = true
+		}
+
+		var pmd parsedMarkdown
+		if err := pmd.parse(f); err != nil {
+			return nil, err
+		}
+		deps, err := pmd.dependencies(f)
+		if err != nil {
+			return nil, err
+		}
+		for _, dep := range deps {
+			if changed[realPath(dep)] {
+				result = append(result, f)
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// readGitInfo returns git metadata of filename by calling the git binary. It
+// returns nil if git is not available or the file is not in a repository.
+func readGitInfo(filename string) *gitInfo {
+	abs := realPath(filename)
+	dir := filepath.Dir(abs)
+
+	root, err := runGit(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil
+	}
+	root = realPath(root)
+	const format = "--format=%H%n%h%n%an%n%cI"
+	out, err := runGit(dir, "log", "-1", format, "--", abs)
+	if err == nil && out == "" {
+		out, err = runGit(dir, "log", "-1", format) // file not committed yet
+	}
+	if err != nil {
+		return nil // no commit yet
+	}
+	fields := strings.Split(out, "\n")
+	if len(fields) != 4 {
+		return nil
+	}
+
+	var info gitInfo
+	info.Commit, info.ShortCommit, info.Author = fields[0], fields[1], fields[2]
+	info.Date, _ = time.Parse(time.RFC3339, fields[3])
+	if branch, err := runGit(dir, "rev-parse", "--abbrev-ref", "HEAD"); err == nil && branch != "HEAD" {
+		info.Branch = branch
+	}
+	if rel, err := filepath.Rel(root, abs); err == nil {
+		info.Path = filepath.ToSlash(rel)
+	}
+	if status, err := runGit(dir, "status", "--porcelain", "--", abs); err == nil && status != "" {
+		info.Dirty = true
+	}
+	return &info
+}
+
+// expandURL sets URL by expanding a text/template with fields of gitInfo,
+// e.g. "https://github.com/org/repo/blob/{{.Commit}}/{{.Path}}".
+func (g *gitInfo) expandURL(urlTemplate string) error {
+	if urlTemplate == "" {
+		return nil
+	}
+	t, err := template.New("source-url").Parse(urlTemplate)
+	if err != nil {
+		return fmt.Errorf("invalid source URL template: %v", err)
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, g); err != nil {
+		return fmt.Errorf("invalid source URL template: %v", err)
+	}
+	g.URL = b.String()
+	return nil
+}
+
+// versionMessage describes the commit for the page history, e.g.
+// "Published from docs/runbook.md at 1a2b3c4 on main by Jane Doe".
+func (g *gitInfo) versionMessage() string {
+	msg := "Published from " + g.Path + " at " + g.ShortCommit
+	if g.Dirty {
+		msg += " with uncommitted changes"
+	}
+	if g.Branch != "" {
+		msg += " on " + g.Branch
+	}
+	msg += " by " + g.Author
+	if g.URL != "" {
+		msg += " " + g.URL
+	}
+	return msg
+}
+
+// footer returns a paragraph telling readers where the page is generated
+// from, to be appended to the document after a horizontal rule.
+func (g *gitInfo) footer() []*blackfriday.Node {
+	text := func(s string) *blackfriday.Node {
+		n := blackfriday.NewNode(blackfriday.Text)
+		n.Literal = []byte(s)
+		return n
+	}
+
+	para := blackfriday.NewNode(blackfriday.Paragraph)
+	para.AppendChild(text("Source: "))
+	if g.URL != "" {
+		link := blackfriday.NewNode(blackfriday.Link)
+		link.Destination = []byte(g.URL)
+		link.AppendChild(text(g.Path))
+		para.AppendChild(link)
+	} else {
+		para.AppendChild(text(g.Path))
+	}
+	para.AppendChild(text(" / Last updated from commit "))
+	code := blackfriday.NewNode(blackfriday.Code)
+	code.Literal = []byte(g.ShortCommit)
+	para.AppendChild(code)
+	if g.Branch != "" {
+		para.AppendChild(text(" on " + g.Branch))
+	}
+	para.AppendChild(text(" by " + g.Author + " at " + g.Date.Format("2006-01-02 15:04 MST")))
+
+	return []*blackfriday.Node{blackfriday.NewNode(blackfriday.HorizontalRule), para}
+}
@@ -0,0 +1,408 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/p47t/md2cfl/bf2confluence"
+	"github.com/russross/blackfriday/v2"
+)
+
+var (
+	// {{< include file="main.go" region="setup" >}}
+	reIncludeShortcode = regexp.MustCompile(`^\{\{<\s*include\s+(.*?)\s*>\}\}$`)
+
+	// Region markers in comments, e.g. "// region setup" and "// endregion"
+	reRegionStart = regexp.MustCompile(`^\s*(?://|#|--|;|%|/\*|<!--)\s*#?region\s+(\S+)`)
+	reRegionEnd   = regexp.MustCompile(`^\s*(?://|#|--|;|%|/\*|<!--)\s*#?endregion\b`)
+
+	reIncludeAttributes = regexp.MustCompile(`\s*\b(?:include|lines|region)=(?:"[^"]*"|'[^']*'|[^\s,}]+)`)
+)
+
+// includeSnippets fills code blocks having an include attribute with the
+// file, relative to dir, optionally limited to lines or a region, e.g.
+//
+//	```go include="../cmd/main.go" lines="10-40"
+//	```
+//
+// A paragraph of {{< include file="main.go" region="setup" >}} is turned into
+// such a code block, with the language guessed from the file name if not
+// given as lang.
+func includeSnippets(doc *blackfriday.Node, dir string) (includes []string, err error) {
+	for n := doc.FirstChild; n != nil; n = n.Next {
+		if n.Type != blackfriday.Paragraph {
+			continue
+		}
+		m := reIncludeShortcode.FindStringSubmatch(strings.TrimSpace(string(literalText(n))))
+		if m == nil {
+			continue
+		}
+		info := bf2confluence.ParseCodeInfo([]byte(m[1]))
+		file, ok := info.Attributes["file"]
+		if !ok {
+			continue // transclusion of markdown
+		}
+		lang := info.Attributes["lang"]
+		if lang == "" {
+			lang = strings.TrimPrefix(filepath.Ext(file), ".")
+		}
+		code := blackfriday.NewNode(blackfriday.CodeBlock)
+		code.IsFenced = true
+		code.Info = []byte(lang + " include=" + strconv.Quote(file) + " " + m[1])
+		n.InsertBefore(code)
+		n.Unlink()
+		n = code
+	}
+
+	doc.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if node.Type != blackfriday.CodeBlock {
+			return blackfriday.GoToNext
+		}
+		info := bf2confluence.ParseCodeInfo(node.Info)
+		file, ok := info.Attributes["include"]
+		if !ok {
+			return blackfriday.GoToNext
+		}
+		var snippet []byte
+		if snippet, err = readSnippet(filepath.Join(dir, file), info.Attributes["lines"], info.Attributes["region"]); err != nil {
+			err = fmt.Errorf("include %s: %v", file, err)
+			return blackfriday.Terminate
+		}
+		node.Literal = snippet
+		node.Info = reIncludeAttributes.ReplaceAll(node.Info, nil) // not to include again
+		includes = append(includes, filepath.ToSlash(filepath.Join(dir, file)))
+		return blackfriday.GoToNext
+	})
+	return includes, err
+}
+
+// transclude replaces paragraphs of {{< include "shared/prereqs.md" >}} in
+// doc with the content of the markdown file relative to dir. Headings of the
+// file are shifted to be under the preceding heading, or by offset if given.
+// Relative links and images are rebased onto dir. stack holds the absolute
+// paths of files being included, to detect cycles.
+func transclude(doc *blackfriday.Node, dir string, stack []string) (includes []string, err error) {
+	type directive struct {
+		para  *blackfriday.Node
+		info  bf2confluence.CodeInfo
+		level int // of the section before any inclusion
+	}
+	var directives []directive
+	for n := doc.FirstChild; n != nil; n = n.Next {
+		if n.Type != blackfriday.Paragraph {
+			continue
+		}
+		m := reIncludeShortcode.FindStringSubmatch(strings.TrimSpace(string(literalText(n))))
+		if m == nil {
+			continue
+		}
+		info := bf2confluence.ParseCodeInfo([]byte(m[1]))
+		if _, ok := info.Attributes["file"]; !ok { // or snippet in code block
+			directives = append(directives, directive{n, info, sectionLevel(n)})
+		}
+	}
+
+	for _, d := range directives {
+		file := filepath.Join(dir, strings.Trim(d.info.Language, `"'`))
+		abs := realPath(file)
+		for i, f := range stack {
+			if f == abs {
+				var cycle []string
+				for _, f := range append(stack[i:], abs) {
+					cycle = append(cycle, filepath.Base(f))
+				}
+				return nil, fmt.Errorf("include cycle %s", strings.Join(cycle, " -> "))
+			}
+		}
+
+		fragment, inner, err := parseFragment(file, append(stack, abs))
+		if err != nil {
+			return nil, err
+		}
+		includes = append(includes, filepath.ToSlash(file))
+		includes = append(includes, inner...)
+
+		offset := 0
+		if v, ok := d.info.Attributes["offset"]; ok {
+			if offset, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("include %s: invalid offset %q", file, v)
+			}
+		} else if d.level > 0 {
+			offset = d.level + 1 - topLevel(fragment)
+		}
+		rebase(fragment, filepath.Dir(file), dir, offset)
+		mergeFootnotes(doc, fragment)
+
+		for c := fragment.FirstChild; c != nil; c = fragment.FirstChild {
+			d.para.InsertBefore(c)
+		}
+		d.para.Unlink()
+	}
+	return includes, nil
+}
+
+// mergeFootnotes moves the footnotes of fragment to the end of those of doc,
+// so that the page has a single list of notes. They are numbered after the
+// footnotes of doc, and labels doc already has are renamed to keep anchors
+// unique.
+func mergeFootnotes(doc, fragment *blackfriday.Node) {
+	notes := footnotesList(fragment)
+	if notes == nil {
+		return
+	}
+	notes.Unlink()
+	list := footnotesList(doc)
+	if list == nil {
+		doc.AppendChild(notes)
+		return
+	}
+
+	used := make(map[string]bool)
+	count := 0
+	for item := list.FirstChild; item != nil; item = item.Next {
+		used[string(item.RefLink)] = true
+		count++
+	}
+	labels := make(map[string][]byte)
+	for item := notes.FirstChild; item != nil; item = item.Next {
+		label := string(item.RefLink)
+		renamed := label
+		for i := 2; used[renamed]; i++ {
+			renamed = fmt.Sprintf("%s-%d", label, i)
+		}
+		used[renamed] = true
+		labels[label] = []byte(renamed)
+		item.RefLink = labels[label]
+	}
+
+	renumber := func(n *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if entering && n.Type == blackfriday.Link && n.NoteID != 0 {
+			n.NoteID += count
+			if label, ok := labels[string(n.Destination)]; ok {
+				n.Destination = label
+			}
+		}
+		return blackfriday.GoToNext
+	}
+	fragment.Walk(renumber)
+	notes.Walk(renumber) // footnotes referring to others
+	for item := notes.FirstChild; item != nil; item = notes.FirstChild {
+		item.ListFlags &^= blackfriday.ListItemBeginningOfList
+		list.AppendChild(item)
+	}
+}
+
+// footnotesList returns the list of footnotes at the end of doc, if any.
+func footnotesList(doc *blackfriday.Node) *blackfriday.Node {
+	if n := doc.LastChild; n != nil && n.Type == blackfriday.List && n.IsFootnotesList {
+		return n
+	}
+	return nil
+}
+
+// parseFragment parses a markdown file, ignoring front matter and the summary
+// divider, with files it includes expanded.
+func parseFragment(filename string, stack []string) (*blackfriday.Node, []string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("include %s: %v", filename, err)
+	}
+	data = reFrontMatter.ReplaceAll(data, nil)
+	data = reShortcode.ReplaceAll(data, nil)
+	data = bytes.Replace(data, summaryDivider, nil, 1)
+	doc := blackfriday.New(blackfriday.WithExtensions(markdownExtensions)).Parse(data)
+
+	dir := filepath.Dir(filename)
+	includes, err := transclude(doc, dir, stack)
+	if err != nil {
+		return nil, nil, err
+	}
+	snippets, err := includeSnippets(doc, dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	pages, err := excerptIncludes(doc, dir, stack[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return doc, append(append(includes, snippets...), pages...), nil
+}
+
+// reFrontMatter matches YAML or TOML front matter.
+var reFrontMatter = regexp.MustCompile(`(?s)\A(?:---\r?\n.*?\r?\n---|\+\+\+\r?\n.*?\r?\n\+\+\+)\r?\n`)
+
+// sectionLevel returns the level of the heading n is under, or 0.
+func sectionLevel(n *blackfriday.Node) int {
+	for p := n.Prev; p != nil; p = p.Prev {
+		if p.Type == blackfriday.Heading {
+			return p.Level
+		}
+	}
+	return 0
+}
+
+// topLevel returns the level of the top heading in doc.
+func topLevel(doc *blackfriday.Node) int {
+	top := 1
+	found := false
+	for n := doc.FirstChild; n != nil; n = n.Next {
+		if n.Type == blackfriday.Heading && (!found || n.Level < top) {
+			top, found = n.Level, true
+		}
+	}
+	return top
+}
+
+// rebase shifts headings in doc by offset, and makes relative destinations of
+// links and images, which are relative to from, relative to to.
+func rebase(doc *blackfriday.Node, from, to string, offset int) {
+	doc.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if !entering {
+			return blackfriday.GoToNext
+		}
+		switch node.Type {
+		case blackfriday.Heading:
+			node.Level += offset
+			if node.Level < 1 {
+				node.Level = 1
+			} else if node.Level > 6 {
+				node.Level = 6
+			}
+		case blackfriday.Link, blackfriday.Image:
+			dest := string(node.Destination)
+			if dest == "" || strings.HasPrefix(dest, "#") || strings.HasPrefix(dest, "/") {
+				break
+			}
+			if _, err := url.ParseRequestURI(dest); err == nil {
+				break // remote
+			}
+			rel, err := filepath.Rel(to, filepath.Join(from, filepath.FromSlash(dest)))
+			if err == nil {
+				node.Destination = []byte(filepath.ToSlash(rel))
+			}
+		}
+		return blackfriday.GoToNext
+	})
+}
+
+// readSnippet returns lines of the file, in ranges such as "10-40", "10-",
+// "-40" and "1-3,7", or in the region marked by comments, with the common
+// indentation removed.
+func readSnippet(filename, lines, region string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimSuffix(strings.Replace(string(data), "\r\n", "\n", -1), "\n")
+	all := strings.Split(text, "\n")
+	selected := all
+
+	if lines != "" {
+		selected = nil
+		for _, r := range strings.Split(lines, ",") {
+			from, to, err := parseLineRange(r, len(all))
+			if err != nil {
+				return nil, err
+			}
+			selected = append(selected, all[from-1:to]...)
+		}
+	}
+	if region != "" {
+		if selected, err = selectRegion(selected, region); err != nil {
+			return nil, err
+		}
+	}
+	if lines != "" || region != "" {
+		selected = dedent(selected)
+	}
+	return []byte(strings.Join(selected, "\n") + "\n"), nil
+}
+
+// parseLineRange returns the first and last line numbers, starting at 1, of
+// a range in a file of n lines.
+func parseLineRange(r string, n int) (from, to int, err error) {
+	r = strings.TrimSpace(r)
+	from, to = 1, n
+	bounds := strings.SplitN(r, "-", 2)
+	if bounds[0] != "" {
+		if from, err = strconv.Atoi(bounds[0]); err != nil {
+			return 0, 0, fmt.Errorf("invalid lines %q", r)
+		}
+	}
+	if len(bounds) == 1 {
+		to = from
+	} else if bounds[1] != "" {
+		if to, err = strconv.Atoi(bounds[1]); err != nil {
+			return 0, 0, fmt.Errorf("invalid lines %q", r)
+		}
+	}
+	if from < 1 || to > n || from > to {
+		return 0, 0, fmt.Errorf("lines %q out of range, the file has %d lines", r, n)
+	}
+	return from, to, nil
+}
+
+// selectRegion returns lines between markers of the named region, without
+// markers of any regions.
+func selectRegion(lines []string, name string) ([]string, error) {
+	var selected []string
+	depth := 0
+	for _, line := range lines {
+		if m := reRegionStart.FindStringSubmatch(line); m != nil {
+			if depth > 0 {
+				depth++
+			} else if m[1] == name {
+				depth = 1
+			}
+			continue
+		}
+		if reRegionEnd.MatchString(line) {
+			if depth > 0 {
+				if depth--; depth == 0 {
+					return selected, nil
+				}
+			}
+			continue
+		}
+		if depth > 0 {
+			selected = append(selected, line)
+		}
+	}
+	if depth > 0 {
+		return nil, fmt.Errorf("region %q is not closed", name)
+	}
+	return nil, fmt.Errorf("region %q not found", name)
+}
+
+// dedent removes indentation common to non-blank lines.
+func dedent(lines []string) []string {
+	prefix := ""
+	first := true
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if first {
+			prefix, first = indent, false
+			continue
+		}
+		for !strings.HasPrefix(indent, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if prefix == "" {
+		return lines
+	}
+	dedented := make([]string, len(lines))
+	for i, line := range lines {
+		dedented[i] = strings.TrimPrefix(line, prefix)
+	}
+	return dedented
+}
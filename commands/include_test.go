@@ -0,0 +1,214 @@
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSource = "package main\n\nfunc main() {\n\t// region setup\n\tx := 1\n\t// region inner\n\t_ = x\n\t// endregion\n\t// endregion\n}\n"
+
+func TestReadSnippet(t *testing.T) {
+	md := writeTestFiles(t, "")
+	src := filepath.Join(filepath.Dir(md), "main.go")
+	if err := ioutil.WriteFile(src, []byte(testSource), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		lines  string
+		region string
+		want   string
+		err    string
+	}{
+		{"", "", testSource, ""},
+		{"3-4", "", "func main() {\n\t// region setup\n", ""},
+		{"1,3", "", "package main\nfunc main() {\n", ""},
+		{"9-", "", "\t// endregion\n}\n", ""},
+		{"-1", "", "package main\n", ""},
+		{"", "setup", "x := 1\n_ = x\n", ""},
+		{"", "inner", "_ = x\n", ""},
+		{"4-7", "setup", "", "region \"setup\" is not closed"},
+		{"", "teardown", "", `region "teardown" not found`},
+		{"0-2", "", "", `lines "0-2" out of range, the file has 10 lines`},
+		{"3-11", "", "", `lines "3-11" out of range`},
+		{"a-b", "", "", `invalid lines "a-b"`},
+	} {
+		got, err := readSnippet(src, tc.lines, tc.region)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("readSnippet(%q, %q) returned error %v, want %q", tc.lines, tc.region, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || string(got) != tc.want {
+			t.Errorf("readSnippet(%q, %q) = %q, %v, want %q", tc.lines, tc.region, got, err, tc.want)
+		}
+	}
+}
+
+func TestIncludeSnippets(t *testing.T) {
+	for _, tc := range []struct {
+		markdown string
+		want     string
+		err      string
+	}{
+		{
+			markdown: "```go include=\"main.go\" lines=\"3-4\"\n```\n",
+			want:     "{code:go}\nfunc main() {\n\t// region setup\n{code}\n\n",
+		},
+		{
+			markdown: "{{< include file=\"main.go\" region=\"setup\" title=\"Setup\" >}}\n",
+			want:     "{code:go|title=Setup}\nx := 1\n_ = x\n{code}\n\n",
+		},
+		{
+			markdown: "{{< include file=\"main.go\" lines=\"1\" lang=\"text\" >}}\n",
+			want:     "{code:text}\npackage main\n{code}\n\n",
+		},
+		{
+			markdown: "{{< include file=\"main.go\" region=\"teardown\" >}}\n",
+			err:      `include main.go: region "teardown" not found`,
+		},
+	} {
+		md := writeTestFiles(t, "---\ntitle: Page\n---\n"+tc.markdown)
+		if err := ioutil.WriteFile(filepath.Join(filepath.Dir(md), "main.go"), []byte(testSource), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if tc.err != "" {
+			var pmd parsedMarkdown
+			if err := pmd.parse(md); err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%q: unexpected error %v", tc.markdown, err)
+			}
+			continue
+		}
+		if got := renderTestFile(t, md); got != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.markdown, got, tc.want)
+		}
+	}
+}
+
+func TestTransclude(t *testing.T) {
+	prereqs := map[string]string{
+		"shared/prereqs.md": "# Prerequisites\n\n[Script](run.sh) ![](../test.png) [Home](http://example.com)\n",
+	}
+	for _, tc := range []struct {
+		name     string
+		markdown string
+		files    map[string]string
+		want     string
+		err      string
+	}{
+		{
+			name:     "under heading",
+			markdown: "## Setup\n\n{{< include \"shared/prereqs.md\" >}}\n\n## After\n",
+			files:    prereqs,
+			want:     "h2. {anchor:setup}Setup\nh3. {anchor:prerequisites}Prerequisites\n[Script|shared/run.sh] !test.png! [Home|http://example.com]\n\nh2. {anchor:after}After\n",
+		},
+		{
+			name:     "offset",
+			markdown: "## Setup\n\n{{< include \"shared/prereqs.md\" offset=1 >}}\n",
+			files:    prereqs,
+			want:     "h2. {anchor:setup}Setup\nh2. {anchor:prerequisites}Prerequisites\n[Script|shared/run.sh] !test.png! [Home|http://example.com]\n\n",
+		},
+		{
+			name:     "front matter and summary",
+			markdown: "Before\n\n{{< include \"part.md\" >}}\n",
+			files:    map[string]string{"part.md": "---\ntitle: Part\n---\nLead. <!--more-->\n"},
+			want:     "Before\n\nLead.\n\n",
+		},
+		{
+			name:     "nested",
+			markdown: "{{< include \"a/outer.md\" >}}\n",
+			files: map[string]string{
+				"a/outer.md":   "# Outer\n\n{{< include \"b/inner.md\" >}}\n",
+				"a/b/inner.md": "# Inner\n\n[Log](log.txt)\n",
+			},
+			want: "h1. {anchor:outer}Outer\nh2. {anchor:inner}Inner\n[Log|a/b/log.txt]\n\n",
+		},
+		{
+			name:     "footnotes",
+			markdown: "Host[^1]\n\n{{< include \"part.md\" >}}\n\n[^1]: Host note\n",
+			files:    map[string]string{"part.md": "Part[^1] again[^x]\n\n[^1]: Part note\n[^x]: Other note\n"},
+			want: "Host{anchor:fnref-1}^[1|#fn-1]^\n\nPart{anchor:fnref-1-2}^[2|#fn-1-2]^ again{anchor:fnref-x}^[3|#fn-x]^\n\n" +
+				"\n----\nh2. Notes\n# {anchor:fn-1}Host note [\u21a9|#fnref-1]\n# {anchor:fn-1-2}Part note [\u21a9|#fnref-1-2]\n# {anchor:fn-x}Other note [\u21a9|#fnref-x]\n\n",
+		},
+		{
+			name:     "footnotes in fragment only",
+			markdown: "{{< include \"part.md\" >}}\n\nAfter\n",
+			files:    map[string]string{"part.md": "Part[^1]\n\n[^1]: Part note\n"},
+			want:     "Part{anchor:fnref-1}^[1|#fn-1]^\n\nAfter\n\n\n----\nh2. Notes\n# {anchor:fn-1}Part note [\u21a9|#fnref-1]\n\n",
+		},
+		{
+			name:     "cycle",
+			markdown: "{{< include \"a.md\" >}}\n",
+			files:    map[string]string{"a.md": "{{< include \"b.md\" >}}\n", "b.md": "{{< include \"a.md\" >}}\n"},
+			err:      "include cycle a.md -> b.md -> a.md",
+		},
+		{
+			name:     "cycle to the page",
+			markdown: "{{< include \"a.md\" >}}\n",
+			files:    map[string]string{"a.md": "{{< include \"doc.md\" >}}\n"},
+			err:      "include cycle doc.md -> a.md -> doc.md",
+		},
+		{
+			name:     "invalid offset",
+			markdown: "{{< include \"shared/prereqs.md\" offset=x >}}\n",
+			files:    prereqs,
+			err:      `invalid offset "x"`,
+		},
+		{
+			name:     "missing",
+			markdown: "{{< include \"missing.md\" >}}\n",
+			err:      "missing.md: no such file or directory",
+		},
+	} {
+		md := writeTestFiles(t, "---\ntitle: Page\n---\n"+tc.markdown)
+		dir := filepath.Dir(md)
+		for name, text := range tc.files {
+			f := filepath.Join(dir, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(f, []byte(text), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if tc.err != "" {
+			var pmd parsedMarkdown
+			if err := pmd.parse(md); err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: unexpected error %v", tc.name, err)
+			}
+			continue
+		}
+		if got := renderTestFile(t, md); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestTranscludeDependencies checks that files included and referred to by
+// included files are attached and make the page changed.
+func TestTranscludeDependencies(t *testing.T) {
+	md := writeTestFiles(t, "---\ntitle: Page\n---\n{{< include \"shared/prereqs.md\" >}}\n", "test.png")
+	dir := filepath.Dir(md)
+	if err := os.Mkdir(filepath.Join(dir, "shared"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "shared", "prereqs.md"), []byte("[Script](run.sh) ![](../test.png)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var pmd parsedMarkdown
+	if err := pmd.parse(md); err != nil {
+		t.Fatal(err)
+	}
+	deps, err := pmd.dependencies(filepath.ToSlash(md))
+	if err != nil {
+		t.Fatal(err)
+	}
+	slash := filepath.ToSlash(dir)
+	want := []string{filepath.ToSlash(md), slash + "/shared/prereqs.md", slash + "/test.png", slash + "/shared/run.sh"}
+	if strings.Join(deps, ",") != strings.Join(want, ",") {
+		t.Errorf("dependencies %q, want %q", deps, want)
+	}
+}
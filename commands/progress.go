@@ -0,0 +1,64 @@
+package commands
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/p47t/md2cfl/confluence"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// progressPrinter logs attachment progress messages, and draws a progress
+// bar of the bytes sent when stderr is a terminal. It is safe for concurrent
+// use.
+type progressPrinter struct {
+	mu      sync.Mutex
+	bar     bool
+	file    string
+	percent int
+}
+
+func newProgressPrinter() *progressPrinter {
+	return &progressPrinter{bar: terminal.IsTerminal(int(os.Stderr.Fd()))}
+}
+
+func (p *progressPrinter) report(pr confluence.Progress) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if pr.Message != "" {
+		log.Println(pr.Message)
+		return
+	}
+	if !p.bar || pr.Total <= 0 {
+		return
+	}
+
+	percent := int(pr.Sent * 100 / pr.Total)
+	if pr.File == p.file && percent == p.percent {
+		return // redraw only when something visible changes
+	}
+	p.file, p.percent = pr.File, percent
+	fmt.Fprintf(os.Stderr, "\r%-32s [%-20s] %3d%% of %s", filepath.Base(pr.File), strings.Repeat("=", percent/5), percent, formatBytes(pr.Total))
+	if pr.Sent >= pr.Total {
+		fmt.Fprintln(os.Stderr)
+		p.file = ""
+	}
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,70 @@
+package commands
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestUniqueHeadingIDs(t *testing.T) {
+	for _, tc := range []struct {
+		markdown string
+		want     string
+	}{
+		{"# Setup\n# Setup\n# Setup\n", "h1. {anchor:setup}Setup\nh1. {anchor:setup-1}Setup\nh1. {anchor:setup-2}Setup\n"},
+		{"# Setup-1\n# Setup\n# Setup\n", "h1. {anchor:setup-1}Setup\\-1\nh1. {anchor:setup}Setup\nh1. {anchor:setup-1-1}Setup\n"},
+		{"# Setup\n# Rollback {#setup}\n[back](#setup-1)\n", "h1. {anchor:setup}Setup\nh1. {anchor:setup-1}Rollback\n[back|#setup-1]\n\n"},
+	} {
+		if got := renderTestFile(t, writeTestFiles(t, "---\ntitle: Page\n---\n"+tc.markdown)); got != tc.want {
+			t.Errorf("got %q, want %q", got, tc.want)
+		}
+	}
+}
+
+func TestRenderDefinitionTables(t *testing.T) {
+	markdown := "Term\n: Def one\n: Def two\n"
+	for _, tc := range []struct {
+		confluence string
+		want       string
+	}{
+		{"{}", "*Term*\n* Def one\n* Def two\n\n"},
+		{"{definition-lists: table}", "||Term|Def one \\\\ Def two|\n\n"},
+	} {
+		md := writeTestFiles(t, "---\nconfluence: "+tc.confluence+"\n---\n"+markdown)
+		if got := renderTestFile(t, md); got != tc.want {
+			t.Errorf("got %q, want %q", got, tc.want)
+		}
+	}
+}
+
+func TestPageFormat(t *testing.T) {
+	aligned := "| A |\n|--:|\n| a |\n"
+	for _, tc := range []struct {
+		name     string
+		markdown string
+		footer   string
+		want     string
+	}{
+		{"plain table", "---\ntitle: Page\n---\n| A |\n|---|\n| a |\n", "", "wiki"},
+		{"aligned table", "---\ntitle: Page\n---\n" + aligned, "", "xml"},
+		{"aligned table with raw footer", "---\ntitle: Page\n---\n" + aligned, "footer.wiki", "wiki"},
+		{"aligned table with markdown footer", "---\ntitle: Page\n---\n" + aligned, "other.md", "xml"},
+		{"storage in front matter", "---\nconfluence:\n  format: xml\n---\nHello\n", "", "xml"},
+	} {
+		md := writeTestFiles(t, tc.markdown)
+		writeBanners(t, filepath.Dir(md))
+		var pmd parsedMarkdown
+		if err := pmd.parse(md); err != nil {
+			t.Fatal(err)
+		}
+		c := &uploadCmd{}
+		if tc.footer != "" {
+			c.footer = filepath.Join(filepath.Dir(md), tc.footer)
+		}
+		if err := c.addBanners(&pmd, md, nil); err != nil {
+			t.Fatal(err)
+		}
+		if got := pmd.format(); got != tc.want {
+			t.Errorf("%s: format %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/russross/blackfriday/v2"
+)
+
+// tocMarkers are paragraphs replaced by a table of contents.
+var tocMarkers = map[string]bool{
+	"[TOC]":       true,
+	"{{< toc >}}": true,
+	"{{<toc>}}":   true,
+}
+
+// addTOC replaces [TOC] markers with a table of contents, or adds one if
+// "toc" in front matter is true or a map of options, e.g.
+//
+//	toc: {maxLevel: 3, position: bottom}
+//
+// Options other than position (top or bottom, where to add the table if
+// there is no marker) and static (build a list of links instead of using
+// the toc macro) are passed to the toc macro.
+func (pf *parsedMarkdown) addTOC() {
+	enabled := true
+	params := make(map[string]string)
+	v, ok := pf.confluenceValue("toc")
+	switch v := v.(type) {
+	case bool:
+		enabled = v
+	case map[interface{}]interface{}: // YAML
+		for k, val := range v {
+			params[fmt.Sprint(k)] = fmt.Sprint(val)
+		}
+	case map[string]interface{}: // TOML
+		for k, val := range v {
+			params[k] = fmt.Sprint(val)
+		}
+	}
+	position := params["position"]
+	static := params["static"] == "true"
+	delete(params, "position")
+	delete(params, "static")
+
+	markers := pf.tocMarkers()
+	if !enabled {
+		for _, m := range markers {
+			m.Unlink()
+		}
+		return
+	}
+	if len(markers) == 0 && ok {
+		m := blackfriday.NewNode(blackfriday.Paragraph)
+		if first := pf.contentAst.FirstChild; first != nil && position != "bottom" {
+			first.InsertBefore(m)
+		} else {
+			pf.contentAst.AppendChild(m)
+		}
+		markers = append(markers, m)
+	}
+
+	for _, m := range markers {
+		var toc *blackfriday.Node
+		if static {
+			toc = pf.tocList(atoi(params["minLevel"], 1), atoi(params["maxLevel"], 6))
+		} else {
+			toc = newMacroParagraph("toc", params)
+		}
+		m.InsertBefore(toc)
+		m.Unlink()
+	}
+}
+
+func atoi(s string, def int) int {
+	if i, err := strconv.Atoi(s); err == nil {
+		return i
+	}
+	return def
+}
+
+// tocMarkers returns paragraphs consisting of a marker only.
+func (pf *parsedMarkdown) tocMarkers() []*blackfriday.Node {
+	var markers []*blackfriday.Node
+	for _, n := range pf.blocks() {
+		if n.Type == blackfriday.Paragraph && tocMarkers[strings.TrimSpace(string(literalText(n)))] {
+			markers = append(markers, n)
+		}
+	}
+	return markers
+}
+
+// blocks returns the top level blocks of the page, those in the bodies of
+// macros such as excerpt in place of the macros.
+func (pf *parsedMarkdown) blocks() []*blackfriday.Node {
+	var blocks []*blackfriday.Node
+	var add func(parent *blackfriday.Node)
+	add = func(parent *blackfriday.Node) {
+		for n := parent.FirstChild; n != nil; n = n.Next {
+			if n.Type == blackfriday.Macro && n.FirstChild != nil {
+				add(n)
+			} else {
+				blocks = append(blocks, n)
+			}
+		}
+	}
+	add(pf.contentAst)
+	return blocks
+}
+
+// literalText returns the concatenated literals of the leaf nodes under n.
+func literalText(n *blackfriday.Node) []byte {
+	var text []byte
+	n.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		switch node.Type {
+		case blackfriday.Text, blackfriday.Code, blackfriday.Macro, blackfriday.HTMLSpan:
+			text = append(text, node.Literal...)
+		}
+		return blackfriday.GoToNext
+	})
+	return text
+}
+
+// tocList returns a nested list of links to headings of the given levels,
+// for Confluence servers without the toc macro.
+func (pf *parsedMarkdown) tocList(minLevel, maxLevel int) *blackfriday.Node {
+	root := blackfriday.NewNode(blackfriday.List)
+	lists := []*blackfriday.Node{root}
+	for _, n := range pf.blocks() {
+		if n.Type != blackfriday.Heading || n.Level < minLevel || n.Level > maxLevel {
+			continue
+		}
+		depth := n.Level - minLevel
+		for len(lists)-1 < depth {
+			parent := lists[len(lists)-1]
+			if parent.LastChild == nil {
+				parent.AppendChild(blackfriday.NewNode(blackfriday.Item))
+			}
+			list := blackfriday.NewNode(blackfriday.List)
+			parent.LastChild.AppendChild(list)
+			lists = append(lists, list)
+		}
+		lists = lists[:depth+1]
+
+		title := string(literalText(n))
+		id := n.HeadingID
+		if id == "" {
+			id = blackfriday.SanitizedAnchorName(title)
+		}
+		text := blackfriday.NewNode(blackfriday.Text)
+		text.Literal = []byte(title)
+		link := blackfriday.NewNode(blackfriday.Link)
+		link.Destination = []byte("#" + id)
+		link.AppendChild(text)
+		para := blackfriday.NewNode(blackfriday.Paragraph)
+		para.AppendChild(link)
+		item := blackfriday.NewNode(blackfriday.Item)
+		item.AppendChild(para)
+		lists[depth].AppendChild(item)
+	}
+	return root
+}
@@ -0,0 +1,26 @@
+package commands
+
+import "testing"
+
+func TestAddTOC(t *testing.T) {
+	for _, tc := range []struct {
+		markdown string
+		want     string
+	}{
+		{"---\nx: 1\n---\n[TOC]\n\n# A\n", "{toc}\n\nh1. {anchor:a}A\n"},
+		{"---\nconfluence:\n  toc: {maxLevel: 2}\n---\n# A\n\n{{< toc >}}\n", "h1. {anchor:a}A\n{toc:maxLevel=2}\n\n"},
+		{"---\nconfluence:\n  toc: true\n---\n# A\n", "{toc}\n\nh1. {anchor:a}A\n"},
+		{"---\nconfluence:\n  toc: false\n---\n[TOC]\n\n# A\n", "h1. {anchor:a}A\n"},
+		{"---\nconfluence:\n  toc: {position: bottom}\n---\n# A\n", "h1. {anchor:a}A\n{toc}\n\n"},
+		{"---\nconfluence:\n  toc: {static: true, position: bottom}\n---\n# A\n## B\n", "h1. {anchor:a}A\nh2. {anchor:b}B\n* [A|#a]\n** [B|#b]\n\n"},
+		{"---\nx: 1\n---\n[TOC]\n\n# A\n\n<!--more-->\n\n# B\n", "{excerpt}\n{toc}\n\nh1. {anchor:a}A\n{excerpt}\n\nh1. {anchor:b}B\n"},
+		{"---\nconfluence:\n  toc: {static: true}\n---\n# A\n\n<!--more-->\n\n# B\n", "* [A|#a]\n* [B|#b]\n\n{excerpt}\nh1. {anchor:a}A\n{excerpt}\n\nh1. {anchor:b}B\n"},
+		{"+++\n[confluence.toc]\nminLevel = 2\n+++\n# A\n", "{toc:minLevel=2}\n\nh1. {anchor:a}A\n"},
+		{"---\nconfluence:\n  format: xml\n  toc: {minLevel: 2}\n---\n# A\n", `<p><ac:structured-macro ac:name="toc"><ac:parameter ac:name="minLevel"><![CDATA[2]]></ac:parameter></ac:structured-macro></p>` + "\n" +
+			`<h1><ac:structured-macro ac:name="anchor"><ac:parameter ac:name="">a</ac:parameter></ac:structured-macro>A</h1>` + "\n"},
+	} {
+		if got := renderTestFile(t, writeTestFiles(t, tc.markdown)); got != tc.want {
+			t.Errorf("got %q, want %q", got, tc.want)
+		}
+	}
+}
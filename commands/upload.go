@@ -1,13 +1,19 @@
 package commands
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/go-yaml/yaml"
@@ -16,6 +22,7 @@ import (
 	"github.com/p47t/md2cfl/parser/pageparser"
 	"github.com/russross/blackfriday/v2"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/zalando/go-keyring"
 	"golang.org/x/crypto/ssh/terminal"
 )
@@ -25,15 +32,39 @@ type uploadCmd struct {
 	pageId string
 	title  string
 	dryrun bool
+	jobs   int
+	prune  bool
+
+	message string
+	minor   bool
+
+	git          bool
+	sourceURL    string
+	sourceFooter bool
+
+	labelCategories bool
+	managedLabels   []string
+
+	header string
+	footer string
+
+	changedSince string
+
+	auths map[string]confluence.AuthMethod // by base URL
 }
 
 func newUploadCmd() *cobra.Command {
 	var c uploadCmd
 	c.Command = &cobra.Command{
-		Use:   "upload [file]",
+		Use:   "upload [file|dir]...",
 		Short: "Upload file to Confluence page",
 		Long: `Upload converted markdown file to specified Confluence page.
 
+Directories are searched recursively for markdown files, of which those with
+a page in front matter are uploaded. With --changed-since, only files changed
+since the given git ref, or including or referring to files changed since
+then, are uploaded.
+
 Note that you may put Confluence-related parameters in front matter, e.g.:
 
 ---
@@ -41,79 +72,337 @@ confluence:
 	base: "http://your.confluence.server"
 	page: "583910399"
 ---
+
+Files not linked from the document may be attached with glob patterns relative
+to the markdown file, optionally listed by an {attachments} macro at the end:
+
+confluence:
+	attachments: ["spec.pdf", "data/*.csv"]
+	attachments-macro: true
+
+A table of contents replaces a paragraph of [TOC] or {{< toc >}}, or is added
+by front matter. Options other than position (top or bottom) and static (a
+list of links instead of the toc macro) are passed to the toc macro:
+
+confluence:
+	toc: {maxLevel: 3, position: top}
+
+Attributes of fenced code blocks are passed to the code macro, e.g.
+
+	` + "```" + `python title="deploy.py" linenumbers=true firstline=10 collapse=true theme=Midnight
+
+Code blocks may include a file relative to the markdown file, limited to
+lines or a region between "// region NAME" and "// endregion" comments:
+
+	` + "```" + `go include="../cmd/main.go" lines="10-40"
+	{{< include file="../cmd/main.go" region="setup" >}}
+
+Other markdown files may be included, with their headings shifted under the
+enclosing section, or by offset=N, and relative links and images rebased:
+
+	{{< include "shared/prereqs.md" >}}
+
+Content before <!--more-->, or summary in front matter, becomes an excerpt
+shown by excerpt-include macros of other pages, added by page title or by
+markdown files, e.g. of child pages:
+
+	{{< excerpt-include "guides/*.md" nopanel=true >}}
+
+Collapsible <details> blocks become expand macros titled by their <summary>.
+
+Pages with tables wiki markup can't represent, i.e. with columns aligned to
+the center or right or with HTML other than <br> in cells, are uploaded in
+storage format, unless a raw header or footer in wiki markup is given.
+
+Definition lists are rendered as bold terms followed by lists of definitions
+in wiki format, or as tables of terms and definitions with:
+
+confluence:
+	definition-lists: table
+
+Tags, and optionally categories, become page labels. Labels matching glob
+patterns of --managed-labels, or managed-labels in front matter, are removed
+when no longer listed. Other labels are left alone.
+
+When the file is in a git repository, the version comment tells the commit,
+branch and author it is published from. A link to the file on the git host
+may be given as a template, and a footer with the same information added:
+
+confluence:
+	source-url: "https://github.com/org/repo/blob/{{.Commit}}/{{.Path}}"
+	source-footer: true
+
+A banner such as "This page is generated from git; edits here will be
+overwritten" may be added with --header and --footer. They are Go templates
+with fields .Path, .Commit, .ShortCommit, .Branch, .Author, .Date, .URL,
+.File, .Title and .Time, and may be replaced or disabled per file:
+
+confluence:
+	header: "../banner.md"
+	footer: false
+
 `,
 		Args: cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			// Parse markdown
-			var pmd parsedMarkdown
-			err := pmd.parse(args[0])
+			files, err := markdownFiles(args)
 			if err != nil {
 				return err
 			}
+			if c.changedSince != "" {
+				if files, err = filterChanged(files, c.changedSince); err != nil {
+					return err
+				}
+				log.Printf("%d file(s) changed since %s", len(files), c.changedSince)
+			}
+			if len(files) > 1 && c.pageId != "" {
+				return fmt.Errorf("--page can't be used with multiple files")
+			}
 
-			// Connect to Wiki
-			baseUrl := pmd.ConfluenceBase(rootCmd.baseUrl)
-			log.Println("Confluence Base:", baseUrl)
-			auth, err := getConfluenceAuth(baseUrl)
-			if err != nil {
-				return err
+			c.auths = make(map[string]confluence.AuthMethod)
+			failed := 0
+			for _, f := range files {
+				if len(files) > 1 {
+					log.Println("Uploading", f)
+				}
+				err := c.upload(rootCmd.ctx, f)
+				if err != nil && len(files) == 1 {
+					return err
+				} else if err != nil {
+					log.Printf("Failed to upload %s: %v", f, err)
+					failed++
+				}
+				if err := rootCmd.ctx.Err(); err != nil {
+					return err
+				}
 			}
-			wiki, err := confluence.NewWiki(baseUrl, auth)
-			if err != nil {
-				return err
+			if failed > 0 {
+				return fmt.Errorf("%d of %d files failed to upload", failed, len(files))
 			}
+			return nil
+		},
+	}
+	c.Command.Flags().StringVarP(&c.pageId, "page", "P", "", "page ID")
+	c.Command.Flags().StringVarP(&c.title, "title", "t", "Page", "page title")
+	c.Command.Flags().BoolVarP(&c.dryrun, "dryrun", "d", false, "don't upload but print wiki text")
+	c.Command.Flags().StringVarP(&c.message, "message", "m", "", "version comment shown in page history (default \"Published from <file>\")")
+	c.Command.Flags().BoolVar(&c.minor, "minor", false, "make a minor edit, which doesn't notify watchers")
+	c.Command.Flags().BoolVar(&c.git, "git", true, "describe the git commit of the file in version comment")
+	c.Command.Flags().StringVar(&c.sourceURL, "source-url", "", "template of link to the file on git host, e.g. 'https://github.com/org/repo/blob/{{.Commit}}/{{.Path}}'")
+	c.Command.Flags().BoolVar(&c.sourceFooter, "source-footer", false, "add a footer telling the source file and commit")
+	c.Command.Flags().StringVar(&c.header, "header", "", "template of banner put at the top of page, in markdown if named *.md or raw wiki/storage format")
+	c.Command.Flags().StringVar(&c.footer, "footer", "", "template of banner put at the bottom of page, in markdown if named *.md or raw wiki/storage format")
+	c.Command.Flags().IntVarP(&c.jobs, "jobs", "j", 1, "number of attachments to upload in parallel")
+	c.Command.Flags().BoolVar(&c.prune, "prune-attachments", false, "delete attachments uploaded earlier but no longer referenced (only reported with --dryrun)")
+	c.Command.Flags().StringVar(&c.changedSince, "changed-since", "", "only upload files changed since the git ref, e.g. 'origin/main'")
+	c.Command.Flags().BoolVar(&c.labelCategories, "label-categories", false, "add categories as well as tags as labels")
+	c.Command.Flags().StringSliceVar(&c.managedLabels, "managed-labels", nil, "glob patterns of labels to remove if not in front matter, e.g. '*'")
+	c.Command.Flags().SetNormalizeFunc(func(f *pflag.FlagSet, name string) pflag.NormalizedName {
+		if name == "dry-run" {
+			name = "dryrun"
+		}
+		return pflag.NormalizedName(name)
+	})
+
+	return c.Command
+}
 
-			pageId := pmd.ConfluencePage(c.pageId)
-			wikiText := pmd.render()
+// upload publishes a markdown file to its Confluence page.
+func (c *uploadCmd) upload(ctx context.Context, filename string) error {
+	// Parse markdown
+	var pmd parsedMarkdown
+	err := pmd.parse(filename)
+	if err != nil {
+		return err
+	}
 
-			if c.dryrun {
-				fmt.Print(string(wikiText))
-				return nil
+	// Collect git metadata
+	var git *gitInfo
+	if c.git {
+		git = readGitInfo(filename)
+	}
+	if git != nil {
+		if err := git.expandURL(pmd.confluenceString("source-url", c.sourceURL)); err != nil {
+			return err
+		}
+		if pmd.confluenceBool("source-footer", c.sourceFooter) {
+			for _, n := range git.footer() {
+				pmd.contentAst.AppendChild(n)
 			}
+		}
+	}
+	if err := c.addBanners(&pmd, filename, git); err != nil {
+		return err
+	}
+
+	// Connect to Wiki
+	baseUrl := pmd.ConfluenceBase(rootCmd.baseUrl)
+	log.Println("Confluence Base:", baseUrl)
+	auth, ok := c.auths[baseUrl]
+	if !ok {
+		// Ask for password only once per server
+		if auth, err = getConfluenceAuth(baseUrl); err != nil {
+			return err
+		}
+		c.auths[baseUrl] = auth
+	}
+	wiki, err := newWiki(baseUrl, auth, confluence.WithConcurrency(c.jobs))
+	if err != nil {
+		return err
+	}
+
+	pageId := pmd.ConfluencePage(c.pageId)
+	format := pmd.format()
+	wikiText := pmd.render(format)
+	attachments, err := pmd.attachments(path.Dir(filename))
+	if err != nil {
+		return err
+	}
+
+	if c.dryrun {
+		fmt.Print(string(wikiText))
+		if c.prune {
+			return pruneAttachments(ctx, wiki, pageId, attachments, true)
+		}
+		return nil
+	}
+
+	// Upload page
+	message := c.message
+	if message == "" && git != nil {
+		message = git.versionMessage()
+	} else if message == "" {
+		message = "Published from " + filepath.ToSlash(filepath.Clean(filename))
+	}
+	webUI, err := uploadPage(ctx, wiki, pageId, format, wikiText, pmd.Title(c.title), message, c.minor)
+	if err != nil {
+		return err
+	}
+
+	// Upload attachments
+	_, errs := wiki.AddUpdateAttachmentsContext(ctx, pageId, attachments, newProgressPrinter().report)
+	for _, err := range errs {
+		log.Println(err) // log but don't report error to caller
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if c.prune {
+		if err := pruneAttachments(ctx, wiki, pageId, attachments, false); err != nil {
+			return err
+		}
+	}
+
+	// Update labels
+	labels := pmd.Tags()
+	if pmd.confluenceBool("label-categories", c.labelCategories) {
+		labels = append(labels, pmd.Categories()...)
+	}
+	managedLabels := pmd.confluenceStrings("managed-labels")
+	if len(managedLabels) == 0 {
+		managedLabels = c.managedLabels
+	}
+	if err := syncLabels(ctx, wiki, pageId, labels, managedLabels); err != nil {
+		return err
+	}
 
-			// Upload page
-			webUI, err := uploadPage(wiki, pageId, pmd.ConfluenceFormat("wiki"), wikiText, pmd.Title(c.title))
+	log.Println("File is uploaded successfully.")
+	if webUI != "" {
+		log.Println("Browse", baseUrl+webUI, "for the result.")
+	}
+	return nil
+}
+
+// markdownFiles expands directories in args to the markdown files under them
+// which have a Confluence page in front matter. Files given explicitly are
+// returned as they are.
+func markdownFiles(args []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		fi, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		err = filepath.Walk(arg, func(p string, fi os.FileInfo, err error) error {
 			if err != nil {
 				return err
 			}
-
-			// Upload attachments
-			mdPath := path.Dir(args[0])
-			var attachments []string
-			for _, dest := range append(pmd.images(), pmd.links()...) {
-				if _, err := url.ParseRequestURI(dest); err == nil {
-					continue // ignore all remote or absolute path
+			if fi.IsDir() {
+				if p != arg && strings.HasPrefix(fi.Name(), ".") {
+					return filepath.SkipDir
 				}
-				attachments = append(attachments, path.Join(mdPath, dest))
+				return nil
 			}
-			_, errs := wiki.AddUpdateAttachments(pageId, attachments, func(msg string) {
-				log.Println(msg)
-			})
-			for _, err := range errs {
-				log.Println(err) // log but don't report error to caller
+			if ext := strings.ToLower(filepath.Ext(p)); ext != ".md" && ext != ".markdown" {
+				return nil
 			}
-
-			// Update labels
-			if labels := pmd.Tags(); len(labels) > 0 {
-				log.Println("Updating labels...")
-				err = wiki.AddLabels(pageId, labels)
-				if err != nil {
-					return err
-				}
+			var pmd parsedMarkdown
+			if err := pmd.parseFrontMatter(p); err != nil && err != errNoFrontMatter {
+				return fmt.Errorf("%s: %v", p, err)
 			}
-
-			log.Println("File is uploaded successfully.")
-			if webUI != "" {
-				log.Println("Browse", baseUrl+webUI, "for the result.")
+			if pmd.ConfluencePage("") == "" {
+				log.Println("Skipping", p, "without Confluence page")
+				return nil
 			}
+			files = append(files, p)
 			return nil
-		},
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
-	c.Command.Flags().StringVarP(&c.pageId, "page", "P", "", "page ID")
-	c.Command.Flags().StringVarP(&c.title, "title", "t", "Page", "page title")
-	c.Command.Flags().BoolVarP(&c.dryrun, "dryrun", "d", false, "don't upload but print wiki text")
+	return files, nil
+}
 
-	return c.Command
+// newWiki returns a client of the Confluence server. Connection settings are
+// taken from flags, or the environment for those set per machine, never from
+// front matter, so that a document can't weaken TLS verification or route
+// credentials through a proxy of its choice.
+func newWiki(baseUrl string, auth confluence.AuthMethod, extraOpts ...confluence.Option) (*confluence.Wiki, error) {
+	opts := []confluence.Option{
+		confluence.WithTimeout(rootCmd.timeout),
+		confluence.WithRetry(rootCmd.retries, time.Second),
+	}
+	if caCert := flagOrEnv(rootCmd.caCert, "MD2CFL_CA_CERT"); caCert != "" {
+		opts = append(opts, confluence.WithCACertFile(caCert))
+	}
+	clientCert := flagOrEnv(rootCmd.clientCert, "MD2CFL_CLIENT_CERT")
+	clientKey := flagOrEnv(rootCmd.clientKey, "MD2CFL_CLIENT_KEY")
+	if clientCert != "" || clientKey != "" {
+		if clientKey == "" {
+			clientKey = clientCert // key may be bundled in the same PEM file
+		}
+		opts = append(opts, confluence.WithClientCertificate(clientCert, clientKey))
+	}
+	noProxy := rootCmd.noProxy
+	if proxy := flagOrEnv(rootCmd.proxy, "MD2CFL_PROXY"); proxy != "" {
+		if noProxy == "" {
+			noProxy = os.Getenv("NO_PROXY")
+		}
+		if noProxy == "" {
+			noProxy = os.Getenv("no_proxy")
+		}
+		opts = append(opts, confluence.WithProxy(proxy, noProxy))
+	} else if noProxy != "" {
+		opts = append(opts, confluence.WithNoProxy(noProxy))
+	}
+	if rootCmd.insecureSkipVerify {
+		log.Println("WARNING: server certificate verification is disabled")
+		opts = append(opts, confluence.WithInsecureSkipVerify())
+	}
+
+	return confluence.NewWiki(baseUrl, auth, append(opts, extraOpts...)...)
+}
+
+// flagOrEnv returns value, or the environment variable if the flag is unset.
+func flagOrEnv(value, env string) string {
+	if value == "" {
+		return os.Getenv(env)
+	}
+	return value
 }
 
 func getConfluenceAuth(baseUrl string) (confluence.AuthMethod, error) {
@@ -145,6 +434,10 @@ func getConfluenceAuth(baseUrl string) (confluence.AuthMethod, error) {
 	return confluence.BasicAuth(userName, password), nil
 }
 
+var markdownExtensions = blackfriday.CommonExtensions&^blackfriday.Autolink | blackfriday.AutoHeadingIDs | blackfriday.Footnotes
+
+const renderFlags = bf2confluence.InformationMacros | bf2confluence.RawConfluenceWiki | bf2confluence.HeadingAnchors
+
 type parsedMarkdown struct {
 	frontMatterSource []byte
 	frontMatter       map[string]interface{}
@@ -152,6 +445,16 @@ type parsedMarkdown struct {
 	// Everything after Front Matter
 	content    []byte
 	contentAst *blackfriday.Node
+
+	// Raw header and footer around the rendered content
+	header []byte
+	footer []byte
+
+	// Files included into the content
+	includes []string
+
+	// Whether the content has <!--more--> after the summary
+	summaryDivider bool
 }
 
 func (pf *parsedMarkdown) Title(def string) string {
@@ -161,38 +464,85 @@ func (pf *parsedMarkdown) Title(def string) string {
 	return def
 }
 
-func (pf *parsedMarkdown) ConfluenceBase(def string) string {
-	if cfl, ok := pf.frontMatter["confluence"]; ok {
-		if base, ok := cfl.(map[interface{}]interface{})["base"]; ok {
-			return base.(string)
+// confluenceValue looks up key in the "confluence" section of front matter.
+func (pf *parsedMarkdown) confluenceValue(key string) (interface{}, bool) {
+	cfl, ok := pf.frontMatter["confluence"]
+	if !ok {
+		return nil, false
+	}
+	switch m := cfl.(type) {
+	case map[interface{}]interface{}: // YAML
+		v, ok := m[key]
+		return v, ok
+	case map[string]interface{}: // TOML
+		v, ok := m[key]
+		return v, ok
+	}
+	return nil, false
+}
+
+func (pf *parsedMarkdown) confluenceString(key, def string) string {
+	if v, ok := pf.confluenceValue(key); ok {
+		if s, ok := v.(string); ok {
+			return s
 		}
 	}
 	return def
 }
 
-func (pf *parsedMarkdown) ConfluencePage(def string) string {
-	if cfl, ok := pf.frontMatter["confluence"]; ok {
-		if page, ok := cfl.(map[interface{}]interface{})["page"]; ok {
-			return page.(string)
+func (pf *parsedMarkdown) confluenceStrings(key string) []string {
+	var ret []string
+	if v, ok := pf.confluenceValue(key); ok {
+		switch v := v.(type) {
+		case string:
+			ret = append(ret, v)
+		case []interface{}:
+			for _, s := range v {
+				if s, ok := s.(string); ok {
+					ret = append(ret, s)
+				}
+			}
 		}
 	}
-	return def
+	return ret
 }
 
-func (pf *parsedMarkdown) ConfluenceFormat(def string) string {
-	if cfl, ok := pf.frontMatter["confluence"]; ok {
-		if format, ok := cfl.(map[interface{}]interface{})["format"]; ok {
-			return format.(string)
+func (pf *parsedMarkdown) confluenceBool(key string, def bool) bool {
+	if v, ok := pf.confluenceValue(key); ok {
+		if b, ok := v.(bool); ok {
+			return b
 		}
 	}
 	return def
 }
 
+func (pf *parsedMarkdown) ConfluenceBase(def string) string {
+	return pf.confluenceString("base", def)
+}
+
+func (pf *parsedMarkdown) ConfluencePage(def string) string {
+	return pf.confluenceString("page", def)
+}
+
+func (pf *parsedMarkdown) ConfluenceFormat(def string) string {
+	return pf.confluenceString("format", def)
+}
+
 func (pf *parsedMarkdown) Tags() []string {
+	return pf.stringList("tags")
+}
+
+func (pf *parsedMarkdown) Categories() []string {
+	return pf.stringList("categories")
+}
+
+func (pf *parsedMarkdown) stringList(key string) []string {
 	var ret []string
-	if tags, ok := pf.frontMatter["tags"]; ok {
-		for _, t := range tags.([]interface{}) {
-			ret = append(ret, t.(string))
+	if list, ok := pf.frontMatter[key].([]interface{}); ok {
+		for _, v := range list {
+			if s, ok := v.(string); ok {
+				ret = append(ret, s)
+			}
 		}
 	}
 	return ret
@@ -200,9 +550,48 @@ func (pf *parsedMarkdown) Tags() []string {
 
 var (
 	reShortcode = regexp.MustCompile(`{{%\s*/?(\S+)\s*%}}`)
+
+	errNoFrontMatter = errors.New("no front matter is provided")
 )
 
 func (pf *parsedMarkdown) parse(filename string) error {
+	if err := pf.parseFrontMatter(filename); err != nil {
+		return err
+	}
+
+	// Remove Hugo shortcode "{{% note %}} ... {{% /note %}}"
+	pf.content = reShortcode.ReplaceAll(pf.content, []byte(``))
+
+	bf := blackfriday.New(blackfriday.WithExtensions(markdownExtensions))
+	pf.contentAst = bf.Parse(pf.content)
+
+	if pf.confluenceBool("attachments-macro", false) {
+		pf.contentAst.AppendChild(newMacroParagraph("attachments", nil))
+	}
+	dir := filepath.Dir(filename)
+	self := realPath(filename)
+	includes, err := transclude(pf.contentAst, dir, []string{self})
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	snippets, err := includeSnippets(pf.contentAst, dir)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	pages, err := excerptIncludes(pf.contentAst, dir, self)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	pf.includes = append(append(includes, snippets...), pages...)
+	uniqueHeadingIDs(pf.contentAst)
+	pf.addExcerpt()
+	pf.addTOC()
+
+	return nil
+}
+
+// parseFrontMatter reads front matter and the content following it.
+func (pf *parsedMarkdown) parseFrontMatter(filename string) error {
 	f, err := os.Open(filename)
 	if err != nil {
 		return err
@@ -214,11 +603,17 @@ func (pf *parsedMarkdown) parse(filename string) error {
 		return err
 	}
 
-	var frontMatterError = fmt.Errorf("no front matter is provided")
+	var frontMatterError = errNoFrontMatter
 	psr.Iterator().PeekWalk(func(item pageparser.Item) bool {
 		if pf.frontMatterSource != nil {
-			pf.content = psr.Input()[item.Pos:]
-			return false
+			if pf.content == nil {
+				pf.content = psr.Input()[item.Pos:]
+			}
+			if item.Type == pageparser.TypeLeadSummaryDivider {
+				pf.summaryDivider = true
+				return false
+			}
+			return true
 		} else if item.IsFrontMatter() {
 			pf.frontMatterSource = item.Val
 
@@ -235,30 +630,141 @@ func (pf *parsedMarkdown) parse(filename string) error {
 		}
 		return true
 	})
-	if frontMatterError != nil {
-		return frontMatterError
-	}
+	return frontMatterError
+}
 
-	// Remove Hugo shortcode "{{% note %}} ... {{% /note %}}"
-	pf.content = reShortcode.ReplaceAll(pf.content, []byte(``))
+// uniqueHeadingIDs appends -1, -2, ... to IDs of headings already used by
+// preceding ones, the same as blackfriday.HTMLRenderer does.
+func uniqueHeadingIDs(doc *blackfriday.Node) {
+	used := make(map[string]int)
+	doc.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if node.Type != blackfriday.Heading || !entering || node.HeadingID == "" {
+			return blackfriday.GoToNext
+		}
+		id := node.HeadingID
+		for count, found := used[id]; found; count, found = used[id] {
+			tmp := fmt.Sprintf("%s-%d", id, count+1)
+			if _, tmpFound := used[tmp]; !tmpFound {
+				used[id] = count + 1
+				id = tmp
+			} else {
+				id = id + "-1"
+			}
+		}
+		used[id] = 0
+		node.HeadingID = id
+		return blackfriday.GoToNext
+	})
+}
 
-	extensions := blackfriday.CommonExtensions & ^blackfriday.Autolink
-	bf := blackfriday.New(blackfriday.WithExtensions(extensions))
-	pf.contentAst = bf.Parse(pf.content)
+// newMacroParagraph returns a paragraph of a Confluence macro, e.g.
+// {attachments} or {toc:maxLevel=3}.
+func newMacroParagraph(name string, params map[string]string) *blackfriday.Node {
+	macro := blackfriday.NewNode(blackfriday.Macro)
+	macro.Name = name
+	macro.Parameters = params
+	var keys []string
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	literal := name
+	for i, k := range keys {
+		if i == 0 {
+			literal += ":"
+		} else {
+			literal += "|"
+		}
+		if k == "" { // default parameter, e.g. {excerpt-include:Page}
+			literal += params[k]
+		} else {
+			literal += k + "=" + params[k]
+		}
+	}
+	macro.Literal = []byte("{" + literal + "}")
+	para := blackfriday.NewNode(blackfriday.Paragraph)
+	para.AppendChild(macro)
+	return para
+}
 
-	return nil
+// format returns the format to render the page in, which is wiki unless
+// specified in front matter. Pages with tables wiki markup can't represent
+// are rendered in storage format instead, unless they have a raw header or
+// footer, which is written in the format of the page as is.
+func (pf *parsedMarkdown) format() string {
+	format := pf.ConfluenceFormat("wiki")
+	if format == "wiki" && bf2confluence.NeedsStorageFormat(pf.contentAst) {
+		if pf.header != nil || pf.footer != nil {
+			log.Println("Rendering in wiki format for raw header or footer, losing alignment or HTML of tables")
+			return format
+		}
+		log.Println("Rendering in storage format for tables with alignment or HTML")
+		return "xml"
+	}
+	return format
 }
 
-func (pf *parsedMarkdown) render() []byte {
-	if pf.ConfluenceFormat("wiki") == "xml" {
+func (pf *parsedMarkdown) render(format string) []byte {
+	flags := renderFlags
+	if pf.confluenceString("definition-lists", "") == "table" {
+		flags |= bf2confluence.DefinitionTables
+	}
+	if format == "xml" {
 		renderer := &bf2confluence.XmlRenderer{
-			Renderer: bf2confluence.Renderer{Flags: bf2confluence.InformationMacros | bf2confluence.RawConfluenceWiki}}
+			Renderer: bf2confluence.Renderer{Flags: flags, Header: pf.header, Footer: pf.footer}}
 		return renderer.Render(pf.contentAst)
 	}
-	renderer := &bf2confluence.Renderer{Flags: bf2confluence.InformationMacros | bf2confluence.RawConfluenceWiki}
+	renderer := &bf2confluence.Renderer{Flags: flags, Header: pf.header, Footer: pf.footer}
 	return renderer.Render(pf.contentAst)
 }
 
+// attachments returns paths of local files referenced by images and links,
+// and those matching glob patterns of "attachments" in front matter. They are
+// relative to dir, the directory of the markdown file.
+func (pf *parsedMarkdown) attachments(dir string) ([]string, error) {
+	var attachments []string
+	seen := make(map[string]bool)
+	add := func(f string) {
+		if !seen[f] {
+			seen[f] = true
+			attachments = append(attachments, f)
+		}
+	}
+
+	for _, dest := range append(pf.images(), pf.links()...) {
+		if _, err := url.ParseRequestURI(dest); err == nil {
+			continue // ignore all remote or absolute path
+		}
+		add(path.Join(dir, dest))
+	}
+	for _, pattern := range pf.confluenceStrings("attachments") {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid attachment pattern %q: %v", pattern, err)
+		}
+		if len(matches) == 0 {
+			log.Println("No file matches attachment pattern", pattern)
+		}
+		for _, m := range matches {
+			if fi, err := os.Stat(m); err == nil && fi.Mode().IsRegular() {
+				add(filepath.ToSlash(m))
+			}
+		}
+	}
+	return attachments, nil
+}
+
+// dependencies returns filename and the local files it includes or refers
+// to, which affect the published page when changed.
+func (pf *parsedMarkdown) dependencies(filename string) ([]string, error) {
+	attachments, err := pf.attachments(path.Dir(filename))
+	if err != nil {
+		return nil, err
+	}
+	deps := append([]string{filename}, pf.includes...)
+	return append(deps, attachments...), nil
+}
+
 func (pf *parsedMarkdown) images() []string {
 	return pf.destinations(blackfriday.Image)
 }
@@ -278,20 +784,108 @@ func (pf *parsedMarkdown) destinations(t blackfriday.NodeType) []string {
 	return destinations
 }
 
-func uploadPage(wiki *confluence.Wiki, pageId, format string, content []byte, title string) (string, error) {
+// pruneAttachments deletes attachments md2cfl uploaded to the page earlier but
+// are no longer among attachments. Attachments added by others are kept.
+func pruneAttachments(ctx context.Context, wiki *confluence.Wiki, pageId string, attachments []string, dryrun bool) error {
+	var keep []string
+	for _, a := range attachments {
+		keep = append(keep, path.Base(a))
+	}
+	stale, err := wiki.StaleAttachmentsContext(ctx, pageId, keep)
+	if err != nil {
+		return err
+	}
+	for _, a := range stale {
+		if dryrun {
+			log.Println("Would delete stale attachment", a.Title, a.Id)
+			continue
+		}
+		log.Println("Deleting stale attachment", a.Title, a.Id)
+		if err := wiki.DeleteAttachmentContext(ctx, pageId, a.Id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncLabels adds labels missing on the page, and removes labels on the page
+// matching managed glob patterns but not in labels. Other labels, e.g. added
+// by humans, are left alone.
+func syncLabels(ctx context.Context, wiki *confluence.Wiki, pageId string, labels, managed []string) error {
+	var want []string
+	wanted := make(map[string]bool)
+	for _, l := range labels {
+		if l = normalizeLabel(l); l != "" && !wanted[l] {
+			wanted[l] = true
+			want = append(want, l)
+		}
+	}
+	if len(managed) == 0 {
+		if len(want) == 0 {
+			return nil
+		}
+		log.Println("Updating labels...")
+		return wiki.AddLabelsContext(ctx, pageId, want)
+	}
+
+	log.Println("Synchronizing labels...")
+	current, err := wiki.GetLabelsContext(ctx, pageId)
+	if err != nil {
+		return err
+	}
+	have := make(map[string]bool)
+	for _, l := range current {
+		have[l.Name] = true
+		if wanted[l.Name] || !matchAny(managed, l.Name) {
+			continue
+		}
+		log.Println("Removing label", l.Name)
+		if err := wiki.RemoveLabelContext(ctx, pageId, l.Name); err != nil {
+			return err
+		}
+	}
+	var add []string
+	for _, l := range want {
+		if !have[l] {
+			add = append(add, l)
+		}
+	}
+	if len(add) == 0 {
+		return nil
+	}
+	log.Println("Adding labels", strings.Join(add, ", "))
+	return wiki.AddLabelsContext(ctx, pageId, add)
+}
+
+// normalizeLabel converts a tag to a Confluence label, which is lower case
+// and has no spaces.
+func normalizeLabel(tag string) string {
+	return strings.ToLower(strings.Join(strings.Fields(tag), "-"))
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func uploadPage(ctx context.Context, wiki *confluence.Wiki, pageId, format string, content []byte, title, message string, minor bool) (string, error) {
 	log.Println("Confluence Page:", pageId)
 
-	page, err := preparePage(wiki, pageId, format, content, title)
+	page, err := preparePage(ctx, wiki, pageId, format, content, title, message, minor)
 	if err != nil {
 		return "", err
 	}
 
-	_, err = wiki.UpdateContent(page)
+	_, err = wiki.UpdateContentContext(ctx, page)
 	return page.Links.WebUI, err
 }
 
-func preparePage(wiki *confluence.Wiki, pageId, format string, content []byte, title string) (*confluence.Content, error) {
-	page, err := wiki.GetContent(pageId, []string{"body", "version"})
+func preparePage(ctx context.Context, wiki *confluence.Wiki, pageId, format string, content []byte, title, message string, minor bool) (*confluence.Content, error) {
+	page, err := wiki.GetContentContext(ctx, pageId, []string{"body", "version"})
 	if err != nil {
 		return nil, err
 	}
@@ -309,6 +903,8 @@ func preparePage(wiki *confluence.Wiki, pageId, format string, content []byte, t
 		page.Body.Storage.Representation = "wiki"
 	}
 	page.Version.Number += 1
+	page.Version.Message = message
+	page.Version.MinorEdit = minor
 
 	return page, nil
 }
@@ -1,15 +1,402 @@
 package commands
 
 import (
+	"bytes"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/p47t/md2cfl/confluence/confluencetest"
+	"github.com/spf13/pflag"
 )
 
+// runCommand executes md2cfl with args, after resetting all flags to their
+// defaults so that tests don't affect each other.
+func runCommand(args ...string) error {
+	reset := func(f *pflag.Flag) {
+		_ = f.Value.Set(f.DefValue)
+		f.Changed = false
+	}
+	rootCmd.PersistentFlags().VisitAll(reset)
+	for _, c := range rootCmd.Commands() {
+		c.Flags().VisitAll(reset)
+	}
+	rootCmd.SetArgs(args)
+	return Execute()
+}
+
+// writeTestFiles copies files from the test directory and writes a markdown
+// document into a temporary directory, returning the path of the document.
+func writeTestFiles(t *testing.T, markdown string, files ...string) string {
+	dir, err := ioutil.TempDir("", "md2cfl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	for _, f := range files {
+		data, err := ioutil.ReadFile(filepath.Join("../test", f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, f), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	md := filepath.Join(dir, "doc.md")
+	if err := ioutil.WriteFile(md, []byte(markdown), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return md
+}
+
+// renderTestFile renders a markdown file in the format of its page as upload
+// does, without banners and git metadata.
+func renderTestFile(t *testing.T, filename string) string {
+	t.Helper()
+	var pmd parsedMarkdown
+	if err := pmd.parse(filename); err != nil {
+		t.Fatal(err)
+	}
+	return string(pmd.render(pmd.format()))
+}
+
+// gitCommit creates a git repository in dir and commits all files in it.
+func gitCommit(t *testing.T, dir string) {
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main"},
+		{"add", "."},
+		{"-c", "user.name=Jane Doe", "-c", "user.email=jane@example.com", "commit", "-q", "-m", "Add doc"},
+	} {
+		if out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+}
+
 func TestUpload(t *testing.T) {
-	oldArgs := os.Args
-	defer func() { os.Args = oldArgs }()
-	os.Args = []string{"cmd", "-u", "yinghau76@gmail.com", "upload", "../test/test.md"}
-	if err := Execute(); err != nil {
+	srv := confluencetest.NewServer()
+	defer srv.Close()
+	pageId := srv.AddPage("TEST", "Old Title", "")
+
+	md := writeTestFiles(t, `---
+title: "Testing Markdown To Confluence"
+tags:
+  - android
+---
+
+## Title 2
+
+![](test.png)
+![](https://upload.wikimedia.org/wikipedia/commons/thumb/3/3a/Cat03.jpg/1200px-Cat03.jpg)
+`, "test.png")
+
+	if err := runCommand("-b", srv.URL, "-u", "user", "-p", "secret", "upload", "-P", pageId, md); err != nil {
+		t.Fatal(err)
+	}
+
+	page, _ := srv.Page(pageId)
+	if page.Version != 2 || page.Title != "Testing Markdown To Confluence" || page.Representation != "wiki" {
+		t.Errorf("unexpected page %+v", page)
+	}
+	if !strings.HasPrefix(page.VersionMessage, "Published from ") || !strings.HasSuffix(page.VersionMessage, "/doc.md") || page.MinorEdit {
+		t.Errorf("unexpected version message %q, minor edit %v", page.VersionMessage, page.MinorEdit)
+	}
+	if !strings.Contains(page.Body, "h2. {anchor:title-2}Title 2") {
+		t.Errorf("unexpected body %q", page.Body)
+	}
+	if len(page.Labels) != 1 || page.Labels[0] != "android" {
+		t.Errorf("unexpected labels %q", page.Labels)
+	}
+
+	png, _ := ioutil.ReadFile("../test/test.png")
+	attachments := srv.Attachments(pageId)
+	if len(attachments) != 1 || attachments[0].Filename != "test.png" || !bytes.Equal(attachments[0].Data, png) {
+		t.Errorf("unexpected attachments %+v", attachments)
+	}
+
+	// Uploading again updates the page but skips the unchanged attachment
+	if err := runCommand("-b", srv.URL, "-u", "user", "-p", "secret", "upload", "-P", pageId, "-m", "Fix typo", "--minor", md); err != nil {
+		t.Fatal(err)
+	}
+	if page, _ := srv.Page(pageId); page.Version != 3 || page.VersionMessage != "Fix typo" || !page.MinorEdit {
+		t.Errorf("unexpected page %+v", page)
+	}
+	if attachments := srv.Attachments(pageId); len(attachments) != 1 || attachments[0].Version != 1 {
+		t.Errorf("unexpected attachments %+v", attachments)
+	}
+
+	// A changed attachment is updated
+	if err := ioutil.WriteFile(filepath.Join(filepath.Dir(md), "test.png"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runCommand("-b", srv.URL, "-u", "user", "-p", "secret", "upload", "-P", pageId, md); err != nil {
+		t.Fatal(err)
+	}
+	if attachments := srv.Attachments(pageId); len(attachments) != 1 || attachments[0].Version != 2 || string(attachments[0].Data) != "changed" {
+		t.Errorf("unexpected attachments %+v", attachments)
+	}
+}
+
+func TestUploadPruneAttachments(t *testing.T) {
+	srv := confluencetest.NewServer()
+	defer srv.Close()
+	pageId := srv.AddPage("TEST", "Page", "")
+	srv.AddAttachment(pageId, "manual.png", "added by hand", []byte("manual"))
+	srv.AddAttachment(pageId, "old.png", "md2cfl: size=3 sha256=0", []byte("old"))
+
+	md := writeTestFiles(t, "---\ntitle: Page\n---\n![](test.png)\n", "test.png")
+
+	// Dry run only reports
+	if err := runCommand("-b", srv.URL, "-u", "user", "-p", "secret", "upload", "-P", pageId, "--dry-run", "--prune-attachments", md); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(srv.Attachments(pageId)); n != 2 {
+		t.Errorf("dry run changed attachments, got %d", n)
+	}
+
+	if err := runCommand("-b", srv.URL, "-u", "user", "-p", "secret", "upload", "-P", pageId, "--dry-run=false", "--prune-attachments", md); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, a := range srv.Attachments(pageId) {
+		names = append(names, a.Filename)
+	}
+	if strings.Join(names, ",") != "manual.png,test.png" {
+		t.Errorf("unexpected attachments %q", names)
+	}
+}
+
+func TestUploadFrontMatterAttachments(t *testing.T) {
+	srv := confluencetest.NewServer()
+	defer srv.Close()
+	pageId := srv.AddPage("TEST", "Page", "")
+
+	md := writeTestFiles(t, `---
+title: Page
+confluence:
+  attachments: ["*.png"]
+  attachments-macro: true
+---
+No links here.
+`, "test.png")
+
+	if err := runCommand("-b", srv.URL, "-u", "user", "-p", "secret", "upload", "-P", pageId, md); err != nil {
+		t.Fatal(err)
+	}
+	if attachments := srv.Attachments(pageId); len(attachments) != 1 || attachments[0].Filename != "test.png" {
+		t.Errorf("unexpected attachments %+v", attachments)
+	}
+	if page, _ := srv.Page(pageId); !strings.HasSuffix(page.Body, "{attachments}\n\n") {
+		t.Errorf("attachments macro missing in %q", page.Body)
+	}
+}
+
+func TestUploadSyncLabels(t *testing.T) {
+	srv := confluencetest.NewServer()
+	defer srv.Close()
+	pageId := srv.AddPage("TEST", "Page", "")
+	srv.AddLabels(pageId, "human", "old-tag", "android")
+
+	md := writeTestFiles(t, `---
+title: Page
+tags: [Android, New Tag]
+categories: [programming]
+---
+Hello
+`)
+
+	if err := runCommand("-b", srv.URL, "-u", "user", "-p", "secret", "upload", "-P", pageId,
+		"--label-categories", "--managed-labels", "*-tag,android,programming", md); err != nil {
+		t.Fatal(err)
+	}
+	page, _ := srv.Page(pageId)
+	if got := strings.Join(page.Labels, ","); got != "human,android,new-tag,programming" {
+		t.Errorf("unexpected labels %q", got)
+	}
+}
+
+func TestUploadGitMetadata(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+	srv := confluencetest.NewServer()
+	defer srv.Close()
+	pageId := srv.AddPage("TEST", "Page", "")
+
+	md := writeTestFiles(t, "---\ntitle: Page\n---\nHello\n")
+	dir := filepath.Dir(md)
+	gitCommit(t, dir)
+	commit, _ := runGit(dir, "rev-parse", "--short", "HEAD")
+
+	// A later commit of another file doesn't change the commit of the page
+	if err := ioutil.WriteFile(filepath.Join(dir, "other.txt"), []byte("other"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{{"add", "."}, {"-c", "user.name=John Roe", "-c", "user.email=john@example.com", "commit", "-q", "-m", "Add other"}} {
+		if _, err := runGit(dir, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := runCommand("-b", srv.URL, "-u", "user", "-p", "secret", "upload", "-P", pageId,
+		"--source-url", "https://git.example.com/blob/{{.Commit}}/{{.Path}}", "--source-footer", md); err != nil {
+		t.Fatal(err)
+	}
+	page, _ := srv.Page(pageId)
+	wantMessage := "Published from doc.md at " + commit + " on main by Jane Doe https://git.example.com/blob/"
+	if !strings.HasPrefix(page.VersionMessage, wantMessage) || !strings.HasSuffix(page.VersionMessage, "/doc.md") {
+		t.Errorf("unexpected version message %q", page.VersionMessage)
+	}
+	if !strings.Contains(page.Body, "Source: [doc.md|https://git.example.com/blob/") || !strings.Contains(page.Body, "{{"+commit+"}}") {
+		t.Errorf("footer missing in %q", page.Body)
+	}
+}
+
+func TestUploadChangedSince(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+	srv := confluencetest.NewServer()
+	defer srv.Close()
+	imagePage := srv.AddPage("TEST", "Image", "")
+	textPage := srv.AddPage("TEST", "Text", "")
+
+	md := writeTestFiles(t, "---\nconfluence:\n  page: \""+imagePage+"\"\n---\n![image](test.png)\n", "test.png")
+	dir := filepath.Dir(md)
+	other := filepath.Join(dir, "sub", "übersicht.md") // quoted by git without -z
+	if err := os.Mkdir(filepath.Dir(other), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(other, []byte("---\nconfluence:\n  page: \""+textPage+"\"\n---\nHello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "notes.md"), []byte("No front matter\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitCommit(t, dir)
+
+	// Nothing changed
+	if err := runCommand("-b", srv.URL, "-u", "user", "-p", "secret", "upload", "--changed-since", "HEAD", dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{imagePage, textPage} {
+		if page, _ := srv.Page(id); page.Version != 1 {
+			t.Errorf("page %s is updated unexpectedly", id)
+		}
+	}
+
+	// Only the image changed
+	f, err := os.OpenFile(filepath.Join(dir, "test.png"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0})
+	f.Close()
+	if err := runCommand("-b", srv.URL, "-u", "user", "-p", "secret", "upload", "--changed-since", "HEAD", dir); err != nil {
+		t.Fatal(err)
+	}
+	if page, _ := srv.Page(imagePage); page.Version != 2 {
+		t.Errorf("page referring to changed image is not updated")
+	}
+	if page, _ := srv.Page(textPage); page.Version != 1 {
+		t.Errorf("unchanged page is updated")
+	}
+
+	// A file with a non-ASCII name changed
+	if err := ioutil.WriteFile(other, []byte("---\nconfluence:\n  page: \""+textPage+"\"\n---\nHallo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runCommand("-b", srv.URL, "-u", "user", "-p", "secret", "upload", "--changed-since", "HEAD", dir); err != nil {
+		t.Fatal(err)
+	}
+	if page, _ := srv.Page(textPage); page.Version != 2 {
+		t.Errorf("changed page with a non-ASCII name is not updated")
+	}
+}
+
+func TestUploadDirectoryParseError(t *testing.T) {
+	srv := confluencetest.NewServer()
+	defer srv.Close()
+	pageID := srv.AddPage("TEST", "Page", "")
+
+	md := writeTestFiles(t, "---\nconfluence:\n  page: \""+pageID+"\"\n---\n{{< include \"missing.md\" >}}\n")
+	err := runCommand("-b", srv.URL, "-u", "user", "-p", "secret", "upload", filepath.Dir(md))
+	if err == nil || !strings.Contains(err.Error(), "missing.md") {
+		t.Errorf("expected error about the missing include, got %v", err)
+	}
+	if err := ioutil.WriteFile(md, []byte("---\nconfluence: [\n---\nHello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = runCommand("-b", srv.URL, "-u", "user", "-p", "secret", "upload", filepath.Dir(md))
+	if err == nil || !strings.Contains(err.Error(), "invalid front matter") {
+		t.Errorf("expected error about invalid front matter, got %v", err)
+	}
+}
+
+// TestUploadRendering is a smoke test of the features rendering a page, which
+// are tested in detail by unit tests.
+func TestUploadRendering(t *testing.T) {
+	srv := confluencetest.NewServer()
+	defer srv.Close()
+	pageId := srv.AddPage("TEST", "Page", "")
+
+	md := writeTestFiles(t, "---\ntitle: Page\nconfluence:\n  toc: true\n---\nLead. <!--more-->\n\n# Setup\n\n"+
+		"{{< include \"shared.md\" >}}\n\n<details><summary>Logs</summary>\nNone\n</details>\n")
+	dir := filepath.Dir(md)
+	if err := ioutil.WriteFile(filepath.Join(dir, "shared.md"), []byte("# Shared\n\nText\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeBanners(t, dir)
+
+	if err := runCommand("-b", srv.URL, "-u", "user", "-p", "secret", "upload", "-P", pageId, "--git=false",
+		"--header", filepath.Join(dir, "header.md"), "--footer", filepath.Join(dir, "footer.wiki"), md); err != nil {
+		t.Fatal(err)
+	}
+	want := "*Generated from " + filepath.ToSlash(md) + "*\n\n{toc}\n\n{excerpt}\nLead.\n\n{excerpt}\n\n" +
+		"h1. {anchor:setup}Setup\nh2. {anchor:shared}Shared\nText\n\n{expand:title=Logs}\nNone\n\n{expand}\n\n" +
+		"{note}Edits to Page will be overwritten{note}\n"
+	if page, _ := srv.Page(pageId); page.Body != want {
+		t.Errorf("unexpected body %q, want %q", page.Body, want)
+	}
+}
+
+func TestUploadConnectionSettingsFromFlagsOnly(t *testing.T) {
+	fake := confluencetest.NewFake()
+	pageId := fake.AddPage("TEST", "Page", "")
+	srv := httptest.NewTLSServer(fake)
+	defer srv.Close()
+
+	md := writeTestFiles(t, "---\ntitle: Page\nconfluence:\n  insecure-skip-verify: true\n---\nHello\n")
+	err := runCommand("-b", srv.URL, "-u", "user", "-p", "secret", "--retries=0", "upload", "-P", pageId, "--git=false", md)
+	if err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := runCommand("-b", srv.URL, "-u", "user", "-p", "secret", "--insecure-skip-verify", "upload", "-P", pageId, "--git=false", md); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUploadConnectionSettingsFromEnvironment(t *testing.T) {
+	fake := confluencetest.NewFake()
+	pageId := fake.AddPage("TEST", "Page", "")
+	srv := httptest.NewTLSServer(fake)
+	defer srv.Close()
+
+	md := writeTestFiles(t, "---\ntitle: Page\n---\nHello\n")
+	caCert := filepath.Join(filepath.Dir(md), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(caCert, certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("MD2CFL_CA_CERT", caCert)
+	defer os.Unsetenv("MD2CFL_CA_CERT")
+	if err := runCommand("-b", srv.URL, "-u", "user", "-p", "secret", "--retries=0", "upload", "-P", pageId, "--git=false", md); err != nil {
 		t.Fatal(err)
 	}
-}
\ No newline at end of file
+}
@@ -1,7 +1,8 @@
 package confluence
 
 import (
-	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,14 +11,23 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 )
 
 // https://docs.atlassian.com/atlassian-confluence/REST/6.5.2/#content/{id}/child/attachment
 
 type Attachments struct {
 	Results []Attachment `json:"results"`
+	Start   int          `json:"start"`
+	Limit   int          `json:"limit"`
 	Size    int          `json:"size"`
+	Links   struct {
+		Next string `json:"next"`
+	} `json:"_links"`
 }
 
 type Attachment struct {
@@ -55,12 +65,17 @@ func (w *Wiki) attachmentDataEndpoint(contentID, attachmentID string) (*url.URL,
 }
 
 func (w *Wiki) DeleteAttachment(contentID string, attachmentID string) error {
+	return w.DeleteAttachmentContext(context.Background(), contentID, attachmentID)
+}
+
+// DeleteAttachmentContext is like DeleteAttachment but takes a context.
+func (w *Wiki) DeleteAttachmentContext(ctx context.Context, contentID string, attachmentID string) error {
 	endpoint, err := w.attachmentEndpoint(contentID, attachmentID)
 	if err != nil {
 		return err
 	}
 
-	req, err := http.NewRequest("DELETE", endpoint.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", endpoint.String(), nil)
 	if err != nil {
 		return err
 	}
@@ -73,12 +88,17 @@ func (w *Wiki) DeleteAttachment(contentID string, attachmentID string) error {
 }
 
 func (w *Wiki) GetAttachment(contentID, attachmentID string) (*Attachment, error) {
+	return w.GetAttachmentContext(context.Background(), contentID, attachmentID)
+}
+
+// GetAttachmentContext is like GetAttachment but takes a context.
+func (w *Wiki) GetAttachmentContext(ctx context.Context, contentID, attachmentID string) (*Attachment, error) {
 	endpoint, err := w.attachmentEndpoint(contentID, attachmentID)
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("GET", endpoint.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint.String(), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -94,13 +114,18 @@ func (w *Wiki) GetAttachment(contentID, attachmentID string) (*Attachment, error
 		return nil, err
 	}
 	if len(attachments.Results) < 1 {
-		return nil, fmt.Errorf("empty list")
+		return nil, notFound(req, "No attachment found with id: "+attachmentID)
 	}
 
 	return &attachments.Results[0], nil
 }
 
 func (w *Wiki) GetAttachmentByFilename(contentID, filename string) (*Attachment, error) {
+	return w.GetAttachmentByFilenameContext(context.Background(), contentID, filename)
+}
+
+// GetAttachmentByFilenameContext is like GetAttachmentByFilename but takes a context.
+func (w *Wiki) GetAttachmentByFilenameContext(ctx context.Context, contentID, filename string) (*Attachment, error) {
 	endpoint, err := w.newAttachmentEndpoint(contentID)
 	if err != nil {
 		return nil, err
@@ -109,7 +134,7 @@ func (w *Wiki) GetAttachmentByFilename(contentID, filename string) (*Attachment,
 	data.Set("filename", filename)
 	endpoint.RawQuery = data.Encode()
 
-	req, err := http.NewRequest("GET", endpoint.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint.String(), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -125,49 +150,92 @@ func (w *Wiki) GetAttachmentByFilename(contentID, filename string) (*Attachment,
 		return nil, err
 	}
 	if len(attachments.Results) < 1 {
-		return nil, fmt.Errorf("empty list")
+		return nil, notFound(req, "No attachment found with file name: "+filename)
 	}
 
 	return &attachments.Results[0], nil
 }
 
-func (w *Wiki) UpdateAttachment(contentID, attachmentID, path string, minorEdit bool) (*Attachment, error) {
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
+// notFound returns an APIError with status 404 for a lookup answered with an
+// empty list, so that IsNotFound tells it from other failures.
+func notFound(req *http.Request, message string) *APIError {
+	return &APIError{
+		StatusCode: http.StatusNotFound,
+		Status:     "404 Not Found",
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		Message:    message,
+	}
+}
 
-	file, err := os.Open(path)
+// ListAttachments returns an iterator over all attachments of a content.
+func (w *Wiki) ListAttachments(contentID string) *AttachmentIterator {
+	return w.ListAttachmentsContext(context.Background(), contentID)
+}
+
+// ListAttachmentsContext is like ListAttachments but takes a context.
+func (w *Wiki) ListAttachmentsContext(ctx context.Context, contentID string) *AttachmentIterator {
+	endpoint, err := w.newAttachmentEndpoint(contentID)
 	if err != nil {
-		return nil, err
+		return &AttachmentIterator{Paginator: &Paginator{err: err}}
 	}
-	defer file.Close()
+	return &AttachmentIterator{Paginator: w.paginate(ctx, endpoint)}
+}
 
-	fi, err := file.Stat()
-	if err != nil {
-		return nil, err
+// IsManaged reports whether the attachment was uploaded by AddAttachment,
+// UpdateAttachment or AddUpdateAttachments, as opposed to added by hand.
+func (a *Attachment) IsManaged() bool {
+	return reChecksum.MatchString(a.Metadata.Comment)
+}
+
+// StaleAttachments returns managed attachments of a content whose file names
+// are not in keep. See Attachment.IsManaged.
+func (w *Wiki) StaleAttachments(contentID string, keep []string) ([]*Attachment, error) {
+	return w.StaleAttachmentsContext(context.Background(), contentID, keep)
+}
+
+// StaleAttachmentsContext is like StaleAttachments but takes a context.
+func (w *Wiki) StaleAttachmentsContext(ctx context.Context, contentID string, keep []string) ([]*Attachment, error) {
+	kept := make(map[string]bool)
+	for _, k := range keep {
+		kept[k] = true
 	}
 
-	part, err := writer.CreateFormFile("file", fi.Name())
-	if err != nil {
-		return nil, err
+	var stale []*Attachment
+	it := w.ListAttachmentsContext(ctx, contentID)
+	for it.Next() {
+		if a := it.Attachment(); a.IsManaged() && !kept[a.Title] {
+			stale = append(stale, a)
+		}
 	}
+	return stale, it.Err()
+}
 
-	_, err = io.Copy(part, file)
+func (w *Wiki) UpdateAttachment(contentID, attachmentID, path string, minorEdit bool) (*Attachment, error) {
+	return w.UpdateAttachmentContext(context.Background(), contentID, attachmentID, path, minorEdit)
+}
+
+// UpdateAttachmentContext is like UpdateAttachment but takes a context.
+func (w *Wiki) UpdateAttachmentContext(ctx context.Context, contentID, attachmentID, path string, minorEdit bool) (*Attachment, error) {
+	comment, err := checksumComment(path)
 	if err != nil {
 		return nil, err
 	}
+	return w.updateAttachment(ctx, contentID, attachmentID, path, comment, minorEdit, nil)
+}
 
-	writer.WriteField("minorEdit", strconv.FormatBool(minorEdit))
-	err = writer.Close()
+func (w *Wiki) updateAttachment(ctx context.Context, contentID, attachmentID, path, comment string, minorEdit bool, progress func(sent, total int64)) (*Attachment, error) {
+	endpoint, err := w.attachmentDataEndpoint(contentID, attachmentID)
 	if err != nil {
 		return nil, err
 	}
-
-	endpoint, err := w.attachmentDataEndpoint(contentID, attachmentID)
+	req, err := newUploadRequest(ctx, endpoint, path, map[string]string{
+		"comment":   comment,
+		"minorEdit": strconv.FormatBool(minorEdit),
+	}, progress)
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest("POST", endpoint.String(), body)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
 
 	res, err := w.sendRequest(req)
 	if err != nil {
@@ -183,77 +251,285 @@ func (w *Wiki) UpdateAttachment(contentID, attachmentID, path string, minorEdit
 }
 
 func (w *Wiki) AddAttachment(contentID, path string) (*Attachment, error) {
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
+	return w.AddAttachmentContext(context.Background(), contentID, path)
+}
 
-	file, err := os.Open(path)
+// AddAttachmentContext is like AddAttachment but takes a context.
+func (w *Wiki) AddAttachmentContext(ctx context.Context, contentID, path string) (*Attachment, error) {
+	comment, err := checksumComment(path)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
+	return w.addAttachment(ctx, contentID, path, comment, nil)
+}
 
-	fi, err := file.Stat()
+func (w *Wiki) addAttachment(ctx context.Context, contentID, path, comment string, progress func(sent, total int64)) (*Attachment, error) {
+	endpoint, err := w.newAttachmentEndpoint(contentID)
 	if err != nil {
 		return nil, err
 	}
-
-	part, err := writer.CreateFormFile("file", fi.Name())
+	req, err := newUploadRequest(ctx, endpoint, path, map[string]string{
+		"comment": comment,
+	}, progress)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = io.Copy(part, file)
+	res, err := w.sendRequest(req)
 	if err != nil {
 		return nil, err
 	}
 
-	err = writer.Close()
+	var attachments Attachments
+	err = json.Unmarshal(res, &attachments)
 	if err != nil {
 		return nil, err
 	}
+	if len(attachments.Results) < 1 {
+		return nil, fmt.Errorf("empty list")
+	}
 
-	endpoint, err := w.newAttachmentEndpoint(contentID)
+	return &attachments.Results[0], nil
+}
+
+// newUploadRequest returns a multipart POST request of the file at path,
+// along with extra form fields. The file is streamed rather than buffered,
+// and progress, if not nil, is called as its bytes are sent.
+func newUploadRequest(ctx context.Context, endpoint *url.URL, path string, fields map[string]string, progress func(sent, total int64)) (*http.Request, error) {
+	fi, err := os.Stat(path)
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest("POST", endpoint.String(), body)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
 
-	res, err := w.sendRequest(req)
-	if err != nil {
+	// Measure the multipart envelope with the same boundary to compute the
+	// content length without reading the file.
+	boundary := multipart.NewWriter(nil).Boundary()
+	var envelope countingWriter
+	if err := writeMultipart(&envelope, boundary, fi.Name(), nil, fields); err != nil {
 		return nil, err
 	}
 
-	var attachments Attachments
-	err = json.Unmarshal(res, &attachments)
+	// getBody opens the file and streams it through a pipe. It is called
+	// again if the request is retried.
+	getBody := func() (io.ReadCloser, error) {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		var body io.Reader = file
+		if progress != nil {
+			body = &progressReader{r: file, total: fi.Size(), progress: progress}
+		}
+		pr, pw := io.Pipe()
+		go func() {
+			defer file.Close()
+			pw.CloseWithError(writeMultipart(pw, boundary, fi.Name(), body, fields))
+		}()
+		return pr, nil
+	}
+
+	body, err := getBody()
 	if err != nil {
 		return nil, err
 	}
-	if len(attachments.Results) < 1 {
-		return nil, fmt.Errorf("empty list")
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint.String(), body)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	req.GetBody = getBody
+	req.Header.Set("Content-Type", "multipart/form-data; boundary="+boundary)
+	if fi.Mode().IsRegular() {
+		req.ContentLength = envelope.n + fi.Size()
 	}
+	return req, nil
+}
 
-	return &attachments.Results[0], nil
+// writeMultipart writes the multipart form of an upload to w. File content is
+// omitted if body is nil.
+func writeMultipart(w io.Writer, boundary, filename string, body io.Reader, fields map[string]string) error {
+	writer := multipart.NewWriter(w)
+	if err := writer.SetBoundary(boundary); err != nil {
+		return err
+	}
+
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		if _, err := io.Copy(part, body); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names) // keep the envelope identical between writes
+	for _, name := range names {
+		if err := writer.WriteField(name, fields[name]); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
 }
 
-func (w *Wiki) AddUpdateAttachments(contentID string, files []string, progress func(msg string)) ([]*Attachment, []error) {
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+type progressReader struct {
+	r        io.Reader
+	sent     int64
+	total    int64
+	progress func(sent, total int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.sent += int64(n)
+		r.progress(r.sent, r.total)
+	}
+	return n, err
+}
+
+// checksumPrefix starts the checksum md2cfl appends to comments of
+// attachments it uploads.
+const checksumPrefix = "md2cfl:"
+
+// reChecksum matches the checksum in an attachment comment.
+var reChecksum = regexp.MustCompile(regexp.QuoteMeta(checksumPrefix) + ` size=\d+ sha256=[0-9a-f]+`)
+
+// withChecksum returns comment with its checksum, if any, replaced by the
+// given one, keeping what else is written there.
+func withChecksum(comment, checksum string) string {
+	comment = strings.TrimSpace(reChecksum.ReplaceAllString(comment, ""))
+	if comment == "" {
+		return checksum
+	}
+	return comment + " " + checksum
+}
+
+// checksumComment returns the checksum recording size and SHA-256 of the
+// file at path, which tells whether the file changed since uploaded.
+func checksumComment(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, file)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s size=%d sha256=%x", checksumPrefix, size, h.Sum(nil)), nil
+}
+
+// Progress reports the state of a file in AddUpdateAttachments. Message is
+// set when the state changes, while Sent and Total are updated as the file is
+// uploaded.
+type Progress struct {
+	File    string
+	Message string
+	Sent    int64
+	Total   int64
+}
+
+func (w *Wiki) AddUpdateAttachments(contentID string, files []string, progress func(Progress)) ([]*Attachment, []error) {
+	return w.AddUpdateAttachmentsContext(context.Background(), contentID, files, progress)
+}
+
+// AddUpdateAttachmentsContext is like AddUpdateAttachments but takes a context.
+func (w *Wiki) AddUpdateAttachmentsContext(ctx context.Context, contentID string, files []string, progress func(Progress)) ([]*Attachment, []error) {
+	// Serialize progress reports from workers
+	var mu sync.Mutex
+	report := func(p Progress) {
+		if progress != nil {
+			mu.Lock()
+			defer mu.Unlock()
+			progress(p)
+		}
+	}
+
+	attachments := make([]*Attachment, len(files))
+	errs := make([]error, len(files))
+	jobs := make(chan int)
+	workers := w.concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < len(files); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				attachments[i], errs[i] = w.addUpdateAttachment(ctx, contentID, files[i], report)
+			}
+		}()
+	}
+feed:
+	for i := range files {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	// Keep input order in results and errors
 	var results []*Attachment
 	var errors []error
-	for _, f := range files {
-		filename := path.Base(f)
-		attachment, err := w.GetAttachmentByFilename(contentID, filename)
-		if err != nil {
-			progress(fmt.Sprintf("Adding new attachment %s", filename))
-			attachment, err = w.AddAttachment(contentID, f)
-		} else {
-			progress(fmt.Sprintf("Updating attachment %s %s", filename, attachment.Id))
-			attachment, err = w.UpdateAttachment(contentID, attachment.Id, f, true)
-		}
-		if err == nil {
-			results = append(results, attachment)
-		} else {
-			errors = append(errors, fmt.Errorf("failed to update attachment %s: %s", f, err.Error()))
+	for i, f := range files {
+		if errs[i] != nil {
+			errors = append(errors, fmt.Errorf("failed to update attachment %s: %s", f, errs[i].Error()))
+		} else if attachments[i] != nil {
+			results = append(results, attachments[i])
 		}
 	}
+	if err := ctx.Err(); err != nil {
+		errors = append(errors, err)
+	}
 	return results, errors
 }
+
+func (w *Wiki) addUpdateAttachment(ctx context.Context, contentID, file string, progress func(Progress)) (*Attachment, error) {
+	filename := path.Base(file)
+	comment, err := checksumComment(file)
+	if err != nil {
+		return nil, err
+	}
+	report := func(msg string) {
+		progress(Progress{File: file, Message: msg})
+	}
+	sent := func(sent, total int64) {
+		progress(Progress{File: file, Sent: sent, Total: total})
+	}
+
+	attachment, err := w.GetAttachmentByFilenameContext(ctx, contentID, filename)
+	if IsNotFound(err) {
+		report(fmt.Sprintf("Adding new attachment %s", filename))
+		return w.addAttachment(ctx, contentID, file, comment, sent)
+	} else if err != nil {
+		return nil, err
+	}
+	if reChecksum.FindString(attachment.Metadata.Comment) == comment {
+		report(fmt.Sprintf("Attachment %s %s unchanged", filename, attachment.Id))
+		return attachment, nil
+	}
+	report(fmt.Sprintf("Updating attachment %s %s", filename, attachment.Id))
+	comment = withChecksum(attachment.Metadata.Comment, comment)
+	return w.updateAttachment(ctx, contentID, attachment.Id, file, comment, true, sent)
+}
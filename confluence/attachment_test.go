@@ -0,0 +1,180 @@
+package confluence_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/p47t/md2cfl/confluence"
+	"github.com/p47t/md2cfl/confluence/confluencetest"
+)
+
+func TestAddUpdateAttachmentsParallel(t *testing.T) {
+	fake := confluencetest.NewFake()
+	pageID := fake.AddPage("DEV", "Page", "")
+
+	// Every upload is rate limited once to exercise retries. Files have
+	// different sizes to tell them apart.
+	var uploads int32
+	var mu sync.Mutex
+	limited := make(map[int64]bool)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			atomic.AddInt32(&uploads, 1)
+			mu.Lock()
+			first := !limited[r.ContentLength]
+			limited[r.ContentLength] = true
+			mu.Unlock()
+			if first {
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "md2cfl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var files []string
+	for i := 0; i < 8; i++ {
+		f := filepath.Join(dir, fmt.Sprintf("file%d.txt", i))
+		if err := ioutil.WriteFile(f, []byte(strings.Repeat("x", i+1)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+	files = append(files, filepath.Join(dir, "missing.txt"))
+
+	wiki, err := confluence.NewWiki(ts.URL, confluence.BasicAuth("user", "pass"),
+		confluence.WithConcurrency(4), confluence.WithRetry(3, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	progress := 0 // not synchronized on purpose; calls must be serialized
+	results, errs := wiki.AddUpdateAttachments(pageID, files, func(p confluence.Progress) { progress++ })
+	if len(errs) != 1 {
+		t.Fatalf("expected only missing.txt to fail, got %v", errs)
+	}
+	if len(results) != 8 {
+		t.Fatalf("expected 8 results, got %d", len(results))
+	}
+	for i, a := range results {
+		if want := filepath.Base(files[i]); a.Title != want {
+			t.Errorf("result %d is %s, want %s", i, a.Title, want)
+		}
+	}
+	if progress == 0 {
+		t.Error("no progress reported")
+	}
+
+	// Nothing is uploaded again when files are unchanged
+	atomic.StoreInt32(&uploads, 0)
+	if _, errs := wiki.AddUpdateAttachments(pageID, files[:8], nil); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if n := atomic.LoadInt32(&uploads); n != 0 {
+		t.Errorf("expected no uploads, got %d", n)
+	}
+}
+
+func TestAddUpdateAttachmentsKeepsComment(t *testing.T) {
+	srv := confluencetest.NewServer()
+	defer srv.Close()
+	pageID := srv.AddPage("DEV", "Page", "")
+	srv.AddAttachment(pageID, "a.txt", "Diagram by Ann", []byte("old"))
+
+	dir, err := ioutil.TempDir("", "md2cfl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "a.txt")
+	if err := ioutil.WriteFile(file, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wiki, err := confluence.NewWiki(srv.URL, confluence.BasicAuth("user", "pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, errs := wiki.AddUpdateAttachments(pageID, []string{file}, nil); len(errs) != 0 {
+			t.Fatal(errs)
+		}
+	}
+	a := srv.Attachments(pageID)
+	if len(a) != 1 || a[0].Version != 2 || !strings.HasPrefix(a[0].Comment, "Diagram by Ann md2cfl: size=3 sha256=") {
+		t.Errorf("unexpected attachments %+v", a)
+	}
+}
+
+func TestAddUpdateAttachmentsLookupError(t *testing.T) {
+	fake := confluencetest.NewFake()
+	pageID := fake.AddPage("DEV", "Page", "")
+	var posts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		atomic.AddInt32(&posts, 1)
+		fake.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	wiki, err := confluence.NewWiki(ts.URL, confluence.BasicAuth("user", "pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, errs := wiki.AddUpdateAttachments(pageID, []string{"attachment_test.go"}, nil)
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "500") {
+		t.Errorf("unexpected errors %v", errs)
+	}
+	if n := atomic.LoadInt32(&posts); n != 0 {
+		t.Errorf("attachment added despite failed lookup")
+	}
+}
+
+// TestNoRetryAfterSent checks that an upload isn't sent again after the
+// server read it, which could add the attachment twice.
+func TestNoRetryAfterSent(t *testing.T) {
+	fake := confluencetest.NewFake()
+	pageID := fake.AddPage("DEV", "Page", "")
+	var posts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && atomic.AddInt32(&posts, 1) == 1 {
+			ioutil.ReadAll(r.Body)
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	wiki, err := confluence.NewWiki(ts.URL, confluence.BasicAuth("user", "pass"), confluence.WithRetry(3, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, errs := wiki.AddUpdateAttachments(pageID, []string{"attachment_test.go"}, nil); len(errs) != 1 {
+		t.Errorf("unexpected errors %v", errs)
+	}
+	if n := atomic.LoadInt32(&posts); n != 1 {
+		t.Errorf("upload sent %d times", n)
+	}
+}
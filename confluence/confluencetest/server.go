@@ -0,0 +1,900 @@
+// Package confluencetest provides an in-memory fake of the Confluence REST
+// API endpoints used by md2cfl, for hermetic tests and offline development.
+package confluencetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Fake is an in-memory Confluence. It implements http.Handler and serves
+// requests under any path containing "/rest/api".
+type Fake struct {
+	// Username and Password, if not empty, are required by basic
+	// authentication of every request.
+	Username string
+	Password string
+
+	// PageSize, if positive, limits the number of results in each page of
+	// listings, to exercise pagination.
+	PageSize int
+
+	mu       sync.Mutex
+	lastID   int
+	contents map[string]*content
+}
+
+// Server is a Fake served by an httptest.Server.
+type Server struct {
+	*httptest.Server
+	*Fake
+}
+
+// NewServer starts a Server with an empty Fake. The caller should call Close
+// when finished.
+func NewServer() *Server {
+	fake := NewFake()
+	return &Server{Server: httptest.NewServer(fake), Fake: fake}
+}
+
+// NewFake returns an empty Fake.
+func NewFake() *Fake {
+	return &Fake{lastID: 10000, contents: make(map[string]*content)}
+}
+
+type content struct {
+	id             string
+	typ            string // "page" or "attachment"
+	status         string
+	title          string
+	space          string
+	parent         string // ID of parent page, or of the container of an attachment
+	value          string
+	representation string
+	version        int
+	versionMessage string
+	minorEdit      bool
+	labels         []string
+
+	// attachment only
+	data      []byte
+	mediaType string
+	comment   string
+}
+
+// Page is a snapshot of a page kept by the Fake.
+type Page struct {
+	ID             string
+	Title          string
+	Space          string
+	Parent         string
+	Body           string
+	Representation string
+	Version        int
+	VersionMessage string
+	MinorEdit      bool
+	Labels         []string
+}
+
+// Attachment is a snapshot of an attachment kept by the Fake.
+type Attachment struct {
+	ID        string
+	Filename  string
+	MediaType string
+	Comment   string
+	Version   int
+	Data      []byte
+}
+
+func (f *Fake) newID() string {
+	f.lastID++
+	return strconv.Itoa(f.lastID)
+}
+
+// AddPage creates a page and returns its ID.
+func (f *Fake) AddPage(space, title, body string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.addPage(space, title, body, "")
+}
+
+// AddChildPage creates a page under parent and returns its ID.
+func (f *Fake) AddChildPage(parent, title, body string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	space := ""
+	if p, ok := f.contents[parent]; ok {
+		space = p.space
+	}
+	return f.addPage(space, title, body, parent)
+}
+
+func (f *Fake) addPage(space, title, body, parent string) string {
+	c := &content{
+		id:             f.newID(),
+		typ:            "page",
+		status:         "current",
+		title:          title,
+		space:          space,
+		parent:         parent,
+		value:          body,
+		representation: "storage",
+		version:        1,
+	}
+	f.contents[c.id] = c
+	return c.id
+}
+
+// Page returns a snapshot of the page with the given ID.
+func (f *Fake) Page(id string) (Page, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.contents[id]
+	if !ok || c.typ != "page" {
+		return Page{}, false
+	}
+	return Page{
+		ID:             c.id,
+		Title:          c.title,
+		Space:          c.space,
+		Parent:         c.parent,
+		Body:           c.value,
+		Representation: c.representation,
+		Version:        c.version,
+		VersionMessage: c.versionMessage,
+		MinorEdit:      c.minorEdit,
+		Labels:         append([]string(nil), c.labels...),
+	}, true
+}
+
+// AddLabels adds global labels to a page.
+func (f *Fake) AddLabels(pageID string, labels ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c, ok := f.contents[pageID]; ok {
+		for _, l := range labels {
+			if !containsString(c.labels, l) {
+				c.labels = append(c.labels, l)
+			}
+		}
+	}
+}
+
+// Attachments returns snapshots of the attachments of a page, ordered by ID.
+func (f *Fake) Attachments(pageID string) []Attachment {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ret []Attachment
+	for _, c := range f.attachments(pageID) {
+		ret = append(ret, Attachment{
+			ID:        c.id,
+			Filename:  c.title,
+			MediaType: c.mediaType,
+			Comment:   c.comment,
+			Version:   c.version,
+			Data:      append([]byte(nil), c.data...),
+		})
+	}
+	return ret
+}
+
+// AddAttachment attaches data to a page and returns the attachment ID.
+func (f *Fake) AddAttachment(pageID, filename, comment string, data []byte) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.addAttachment(pageID, filename, comment, data).id
+}
+
+func (f *Fake) addAttachment(pageID, filename, comment string, data []byte) *content {
+	c := &content{
+		id:        f.newID(),
+		typ:       "attachment",
+		status:    "current",
+		title:     filename,
+		parent:    pageID,
+		version:   1,
+		data:      data,
+		mediaType: http.DetectContentType(data),
+		comment:   comment,
+	}
+	f.contents[c.id] = c
+	return c
+}
+
+func (f *Fake) attachments(pageID string) []*content {
+	var ret []*content
+	for _, c := range f.contents {
+		if c.typ == "attachment" && c.parent == pageID {
+			ret = append(ret, c)
+		}
+	}
+	sortContents(ret)
+	return ret
+}
+
+func sortContents(cs []*content) {
+	sort.Slice(cs, func(i, j int) bool {
+		a, _ := strconv.Atoi(cs[i].id)
+		b, _ := strconv.Atoi(cs[j].id)
+		return a < b
+	})
+}
+
+// JSON representations, following
+// https://docs.atlassian.com/atlassian-confluence/REST/6.5.2/
+
+type jsonSpace struct {
+	Key string `json:"key"`
+}
+
+type jsonAncestor struct {
+	Id string `json:"id"`
+}
+
+type jsonStorage struct {
+	Value          string `json:"value"`
+	Representation string `json:"representation"`
+}
+
+type jsonVersion struct {
+	Number    int    `json:"number"`
+	Message   string `json:"message,omitempty"`
+	MinorEdit bool   `json:"minorEdit"`
+}
+
+type jsonBody struct {
+	Storage jsonStorage `json:"storage"`
+}
+
+type jsonMetadata struct {
+	Comment   string `json:"comment"`
+	MediaType string `json:"mediaType"`
+}
+
+type jsonExtensions struct {
+	FileSize int `json:"fileSize"`
+}
+
+type jsonLinks struct {
+	WebUI    string `json:"webui,omitempty"`
+	Download string `json:"download,omitempty"`
+}
+
+type jsonContent struct {
+	Id         string          `json:"id"`
+	Type       string          `json:"type"`
+	Status     string          `json:"status"`
+	Title      string          `json:"title"`
+	Space      *jsonSpace      `json:"space,omitempty"`
+	Ancestors  []jsonAncestor  `json:"ancestors,omitempty"`
+	Body       *jsonBody       `json:"body,omitempty"`
+	Version    jsonVersion     `json:"version"`
+	Metadata   *jsonMetadata   `json:"metadata,omitempty"`
+	Extensions *jsonExtensions `json:"extensions,omitempty"`
+	Links      jsonLinks       `json:"_links"`
+}
+
+type jsonLabel struct {
+	Prefix string `json:"prefix"`
+	Name   string `json:"name"`
+	Id     string `json:"id,omitempty"`
+}
+
+func (f *Fake) toJSON(c *content) *jsonContent {
+	j := &jsonContent{
+		Id:      c.id,
+		Type:    c.typ,
+		Status:  c.status,
+		Title:   c.title,
+		Version: jsonVersion{Number: c.version, Message: c.versionMessage, MinorEdit: c.minorEdit},
+	}
+	if c.typ == "attachment" {
+		j.Metadata = &jsonMetadata{Comment: c.comment, MediaType: c.mediaType}
+		j.Extensions = &jsonExtensions{FileSize: len(c.data)}
+		j.Links.Download = "/download/attachments/" + c.parent + "/" + url.PathEscape(c.title)
+		return j
+	}
+	if c.space != "" {
+		j.Space = &jsonSpace{Key: c.space}
+	}
+	ancestors := f.ancestors(c)
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		j.Ancestors = append(j.Ancestors, jsonAncestor{Id: ancestors[i]})
+	}
+	j.Body = &jsonBody{Storage: jsonStorage{Value: c.value, Representation: c.representation}}
+	j.Links.WebUI = "/pages/viewpage.action?pageId=" + c.id
+	return j
+}
+
+// ancestors returns IDs of the ancestors of c, nearest first.
+func (f *Fake) ancestors(c *content) []string {
+	var ret []string
+	for p := c.parent; p != ""; {
+		ret = append(ret, p)
+		if pc, ok := f.contents[p]; ok {
+			p = pc.parent
+		} else {
+			p = ""
+		}
+	}
+	return ret
+}
+
+type results struct {
+	Results []interface{} `json:"results"`
+	Start   int           `json:"start"`
+	Limit   int           `json:"limit"`
+	Size    int           `json:"size"`
+	Links   struct {
+		Base    string `json:"base"`
+		Context string `json:"context"`
+		Next    string `json:"next,omitempty"`
+	} `json:"_links"`
+}
+
+// writeResults writes the page of items selected by the start and limit
+// query parameters, linking to the next page if there are more items.
+func (f *Fake) writeResults(w http.ResponseWriter, r *http.Request, items []interface{}) {
+	q := r.URL.Query()
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 25
+	}
+	if f.PageSize > 0 && limit > f.PageSize {
+		limit = f.PageSize
+	}
+	start, _ := strconv.Atoi(q.Get("start"))
+	if start < 0 || start > len(items) {
+		start = len(items)
+	}
+	end := start + limit
+	if end > len(items) {
+		end = len(items)
+	}
+
+	res := &results{Results: items[start:end], Start: start, Limit: limit, Size: end - start}
+	if res.Results == nil {
+		res.Results = []interface{}{}
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	res.Links.Context = r.URL.Path[:strings.Index(r.URL.Path, "/rest/api")]
+	res.Links.Base = scheme + "://" + r.Host + res.Links.Context
+	if end < len(items) {
+		q.Set("start", strconv.Itoa(end))
+		q.Set("limit", strconv.Itoa(limit))
+		res.Links.Next = strings.TrimPrefix(r.URL.Path, res.Links.Context) + "?" + q.Encode()
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, map[string]interface{}{
+		"statusCode": status,
+		"message":    fmt.Sprintf(format, args...),
+		"reason":     http.StatusText(status),
+	})
+}
+
+var (
+	reContent            = regexp.MustCompile(`^/content/?$`)
+	reContentSearch      = regexp.MustCompile(`^/content/search/?$`)
+	reContentID          = regexp.MustCompile(`^/content/(\d+)/?$`)
+	reChildPage          = regexp.MustCompile(`^/content/(\d+)/child/page/?$`)
+	reChildAttachment    = regexp.MustCompile(`^/content/(\d+)/child/attachment/?$`)
+	reAttachment         = regexp.MustCompile(`^/content/(\d+)/child/attachment/(\d+)/?$`)
+	reAttachmentData     = regexp.MustCompile(`^/content/(\d+)/child/attachment/(\d+)/data/?$`)
+	reLabel              = regexp.MustCompile(`^/content/(\d+)/label/?$`)
+	reLabelName          = regexp.MustCompile(`^/content/(\d+)/label/([^/]+)/?$`)
+	reDownloadAttachment = regexp.MustCompile(`^/download/attachments/(\d+)/([^/]+)$`)
+)
+
+// ServeHTTP implements http.Handler.
+func (f *Fake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.Username != "" || f.Password != "" {
+		if u, p, ok := r.BasicAuth(); !ok || u != f.Username || p != f.Password {
+			writeError(w, http.StatusUnauthorized, "Authentication failed")
+			return
+		}
+	}
+
+	// Read request bodies before locking, so that slow uploads don't hold up
+	// other requests
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		_ = r.ParseMultipartForm(32 << 20) // errors are reported by handlers
+	} else if r.Body != nil {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Cannot read request: %s", err)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p := r.URL.Path
+	if m := reDownloadAttachment.FindStringSubmatch(p); m != nil {
+		f.download(w, r, m[1], m[2])
+		return
+	}
+	i := strings.Index(p, "/rest/api")
+	if i < 0 {
+		writeError(w, http.StatusNotFound, "No endpoint at %s", p)
+		return
+	}
+	p = p[i+len("/rest/api"):]
+
+	var m []string
+	route := func(re *regexp.Regexp) bool {
+		m = re.FindStringSubmatch(p)
+		return m != nil
+	}
+	switch {
+	case route(reContent):
+		switch r.Method {
+		case "GET":
+			f.listContent(w, r)
+		case "POST":
+			f.createContent(w, r)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "%s not allowed", r.Method)
+		}
+	case route(reContentSearch):
+		f.search(w, r)
+	case route(reContentID):
+		switch r.Method {
+		case "GET":
+			f.getContent(w, r, m[1])
+		case "PUT":
+			f.updateContent(w, r, m[1])
+		case "DELETE":
+			f.deleteContent(w, r, m[1])
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "%s not allowed", r.Method)
+		}
+	case route(reChildPage):
+		f.childPages(w, r, m[1])
+	case route(reChildAttachment):
+		switch r.Method {
+		case "GET":
+			f.listAttachments(w, r, m[1])
+		case "POST":
+			f.addAttachments(w, r, m[1])
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "%s not allowed", r.Method)
+		}
+	case route(reAttachment):
+		switch r.Method {
+		case "GET":
+			f.getAttachment(w, r, m[1], m[2])
+		case "DELETE":
+			f.deleteContent(w, r, m[2])
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "%s not allowed", r.Method)
+		}
+	case route(reAttachmentData):
+		f.updateAttachmentData(w, r, m[1], m[2])
+	case route(reLabel):
+		switch r.Method {
+		case "GET":
+			f.listLabels(w, r, m[1])
+		case "POST":
+			f.addLabels(w, r, m[1])
+		case "DELETE":
+			f.removeLabel(w, r, m[1], r.URL.Query().Get("name"))
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "%s not allowed", r.Method)
+		}
+	case route(reLabelName):
+		if r.Method != "DELETE" {
+			writeError(w, http.StatusMethodNotAllowed, "%s not allowed", r.Method)
+			return
+		}
+		f.removeLabel(w, r, m[1], m[2])
+	default:
+		writeError(w, http.StatusNotFound, "No endpoint at %s", r.URL.Path)
+	}
+}
+
+func (f *Fake) page(w http.ResponseWriter, id string) *content {
+	c, ok := f.contents[id]
+	if !ok || c.typ != "page" {
+		writeError(w, http.StatusNotFound, "No content found with id: ContentId{id=%s}", id)
+		return nil
+	}
+	return c
+}
+
+func (f *Fake) getContent(w http.ResponseWriter, r *http.Request, id string) {
+	c, ok := f.contents[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "No content found with id: ContentId{id=%s}", id)
+		return
+	}
+	writeJSON(w, http.StatusOK, f.toJSON(c))
+}
+
+func (f *Fake) listContent(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var items []*content
+	for _, c := range f.contents {
+		if c.typ != "page" {
+			continue
+		}
+		if title := q.Get("title"); title != "" && c.title != title {
+			continue
+		}
+		if space := q.Get("spaceKey"); space != "" && c.space != space {
+			continue
+		}
+		items = append(items, c)
+	}
+	f.writeContents(w, r, items)
+}
+
+func (f *Fake) writeContents(w http.ResponseWriter, r *http.Request, cs []*content) {
+	sortContents(cs)
+	var items []interface{}
+	for _, c := range cs {
+		items = append(items, f.toJSON(c))
+	}
+	f.writeResults(w, r, items)
+}
+
+func (f *Fake) createContent(w http.ResponseWriter, r *http.Request) {
+	var req jsonContent
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Cannot parse request: %s", err)
+		return
+	}
+	if req.Title == "" {
+		writeError(w, http.StatusBadRequest, "A page must have a title")
+		return
+	}
+	space := ""
+	if req.Space != nil {
+		space = req.Space.Key
+	}
+	parent := ""
+	if n := len(req.Ancestors); n > 0 {
+		parent = req.Ancestors[n-1].Id
+		if f.page(w, parent) == nil {
+			return
+		}
+		if space == "" {
+			space = f.contents[parent].space
+		}
+	}
+	for _, c := range f.contents {
+		if c.typ == "page" && c.space == space && c.title == req.Title {
+			writeError(w, http.StatusBadRequest, "A page with this title already exists: A page already exists with the title %s in the space with key %s", req.Title, space)
+			return
+		}
+	}
+	id := f.addPage(space, req.Title, "", parent)
+	c := f.contents[id]
+	if req.Body != nil {
+		c.value = req.Body.Storage.Value
+		c.representation = req.Body.Storage.Representation
+	}
+	c.versionMessage = req.Version.Message
+	writeJSON(w, http.StatusOK, f.toJSON(c))
+}
+
+func (f *Fake) updateContent(w http.ResponseWriter, r *http.Request, id string) {
+	c := f.page(w, id)
+	if c == nil {
+		return
+	}
+	var req jsonContent
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Cannot parse request: %s", err)
+		return
+	}
+	if req.Version.Number != c.version+1 {
+		writeError(w, http.StatusConflict, "Version must be incremented on update. Current version is: %d", c.version)
+		return
+	}
+	if req.Title == "" {
+		writeError(w, http.StatusBadRequest, "A page must have a title")
+		return
+	}
+	c.title = req.Title
+	if req.Body != nil {
+		c.value = req.Body.Storage.Value
+		c.representation = req.Body.Storage.Representation
+	}
+	c.version = req.Version.Number
+	c.versionMessage = req.Version.Message
+	c.minorEdit = req.Version.MinorEdit
+	writeJSON(w, http.StatusOK, f.toJSON(c))
+}
+
+func (f *Fake) deleteContent(w http.ResponseWriter, r *http.Request, id string) {
+	if _, ok := f.contents[id]; !ok {
+		writeError(w, http.StatusNotFound, "No content found with id: ContentId{id=%s}", id)
+		return
+	}
+	for _, c := range f.contents {
+		if c.parent == id && c.typ == "attachment" {
+			delete(f.contents, c.id)
+		}
+	}
+	delete(f.contents, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *Fake) childPages(w http.ResponseWriter, r *http.Request, id string) {
+	if f.page(w, id) == nil {
+		return
+	}
+	var items []*content
+	for _, c := range f.contents {
+		if c.typ == "page" && c.parent == id {
+			items = append(items, c)
+		}
+	}
+	f.writeContents(w, r, items)
+}
+
+// search supports a small subset of CQL: clauses of the form
+// field = "value" joined by AND, where field is one of type, space, title,
+// label, parent and ancestor.
+func (f *Fake) search(w http.ResponseWriter, r *http.Request) {
+	clauses, err := parseCQL(r.URL.Query().Get("cql"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Could not parse cql: %s", err)
+		return
+	}
+	var items []*content
+	for _, c := range f.contents {
+		if f.matchCQL(c, clauses) {
+			items = append(items, c)
+		}
+	}
+	f.writeContents(w, r, items)
+}
+
+var reCQLClause = regexp.MustCompile(`^\s*(\w+)\s*=\s*(?:"([^"]*)"|(\S+))\s*$`)
+
+func parseCQL(cql string) (map[string]string, error) {
+	clauses := make(map[string]string)
+	if strings.TrimSpace(cql) == "" {
+		return clauses, nil
+	}
+	for _, clause := range regexp.MustCompile(`(?i)\s+and\s+`).Split(cql, -1) {
+		m := reCQLClause.FindStringSubmatch(clause)
+		if m == nil {
+			return nil, fmt.Errorf("unsupported clause %q", clause)
+		}
+		clauses[strings.ToLower(m[1])] = m[2] + m[3]
+	}
+	return clauses, nil
+}
+
+func (f *Fake) matchCQL(c *content, clauses map[string]string) bool {
+	for field, value := range clauses {
+		switch field {
+		case "type":
+			if c.typ != value {
+				return false
+			}
+		case "space":
+			if c.space != value {
+				return false
+			}
+		case "title":
+			if c.title != value {
+				return false
+			}
+		case "parent":
+			if c.parent != value {
+				return false
+			}
+		case "ancestor":
+			if !containsString(f.ancestors(c), value) {
+				return false
+			}
+		case "label":
+			if !containsString(c.labels, value) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (f *Fake) listAttachments(w http.ResponseWriter, r *http.Request, pageID string) {
+	if f.page(w, pageID) == nil {
+		return
+	}
+	filename := r.URL.Query().Get("filename")
+	var items []*content
+	for _, c := range f.attachments(pageID) {
+		if filename == "" || c.title == filename {
+			items = append(items, c)
+		}
+	}
+	f.writeContents(w, r, items)
+}
+
+func (f *Fake) getAttachment(w http.ResponseWriter, r *http.Request, pageID, id string) {
+	c, ok := f.contents[id]
+	if !ok || c.typ != "attachment" || c.parent != pageID {
+		writeError(w, http.StatusNotFound, "No attachment found with id: %s", id)
+		return
+	}
+	f.writeContents(w, r, []*content{c})
+}
+
+func (f *Fake) addAttachments(w http.ResponseWriter, r *http.Request, pageID string) {
+	if f.page(w, pageID) == nil {
+		return
+	}
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeError(w, http.StatusBadRequest, "Cannot parse multipart request: %s", err)
+		return
+	}
+	files := r.MultipartForm.File["file"]
+	if len(files) == 0 {
+		writeError(w, http.StatusBadRequest, "No file attached")
+		return
+	}
+	comments := r.MultipartForm.Value["comment"]
+	var items []*content
+	for i, fh := range files {
+		for _, c := range f.attachments(pageID) {
+			if c.title == fh.Filename {
+				writeError(w, http.StatusBadRequest, "Cannot add a new attachment with same file name as an existing attachment: %s", fh.Filename)
+				return
+			}
+		}
+		data, err := readFile(fh)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Cannot read %s: %s", fh.Filename, err)
+			return
+		}
+		comment := ""
+		if i < len(comments) {
+			comment = comments[i]
+		}
+		items = append(items, f.addAttachment(pageID, fh.Filename, comment, data))
+	}
+	f.writeContents(w, r, items)
+}
+
+func (f *Fake) updateAttachmentData(w http.ResponseWriter, r *http.Request, pageID, id string) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, "%s not allowed", r.Method)
+		return
+	}
+	c, ok := f.contents[id]
+	if !ok || c.typ != "attachment" || c.parent != pageID {
+		writeError(w, http.StatusNotFound, "No attachment found with id: %s", id)
+		return
+	}
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeError(w, http.StatusBadRequest, "Cannot parse multipart request: %s", err)
+		return
+	}
+	files := r.MultipartForm.File["file"]
+	if len(files) != 1 {
+		writeError(w, http.StatusBadRequest, "Exactly one file must be attached")
+		return
+	}
+	data, err := readFile(files[0])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Cannot read %s: %s", files[0].Filename, err)
+		return
+	}
+	c.data = data
+	c.mediaType = http.DetectContentType(data)
+	if comments := r.MultipartForm.Value["comment"]; len(comments) > 0 {
+		c.comment = comments[0]
+	}
+	c.version++
+	c.minorEdit = r.FormValue("minorEdit") == "true"
+	writeJSON(w, http.StatusOK, f.toJSON(c))
+}
+
+func (f *Fake) download(w http.ResponseWriter, r *http.Request, pageID, filename string) {
+	name, err := url.PathUnescape(filename)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid file name %s", filename)
+		return
+	}
+	for _, c := range f.attachments(pageID) {
+		if c.title == name {
+			w.Header().Set("Content-Type", c.mediaType)
+			_, _ = w.Write(c.data)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "No attachment %s", name)
+}
+
+func (f *Fake) listLabels(w http.ResponseWriter, r *http.Request, pageID string) {
+	c := f.page(w, pageID)
+	if c == nil {
+		return
+	}
+	var items []interface{}
+	for _, l := range c.labels {
+		items = append(items, jsonLabel{Prefix: "global", Name: l, Id: l})
+	}
+	f.writeResults(w, r, items)
+}
+
+func (f *Fake) addLabels(w http.ResponseWriter, r *http.Request, pageID string) {
+	c := f.page(w, pageID)
+	if c == nil {
+		return
+	}
+	var labels []jsonLabel
+	if err := json.NewDecoder(r.Body).Decode(&labels); err != nil {
+		writeError(w, http.StatusBadRequest, "Cannot parse request: %s", err)
+		return
+	}
+	for _, l := range labels {
+		if !containsString(c.labels, l.Name) {
+			c.labels = append(c.labels, l.Name)
+		}
+	}
+	f.listLabels(w, r, pageID)
+}
+
+func (f *Fake) removeLabel(w http.ResponseWriter, r *http.Request, pageID, name string) {
+	c := f.page(w, pageID)
+	if c == nil {
+		return
+	}
+	for i, l := range c.labels {
+		if l == name {
+			c.labels = append(c.labels[:i], c.labels[i+1:]...)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "Label %s not found", name)
+}
+
+func readFile(fh *multipart.FileHeader) ([]byte, error) {
+	file, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ioutil.ReadAll(file)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,215 @@
+package confluencetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// do sends a request to the server and decodes the JSON response into v,
+// returning the status code.
+func do(t *testing.T, srv *Server, method, path string, body io.Reader, contentType string, v interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+"/rest/api"+path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if v != nil && res.StatusCode < 300 {
+		if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return res.StatusCode
+}
+
+func multipartBody(t *testing.T, filename, data, comment string) (io.Reader, string) {
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+	fw, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte(data))
+	mw.WriteField("comment", comment)
+	mw.Close()
+	return &b, mw.FormDataContentType()
+}
+
+func TestContent(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	var created jsonContent
+	if code := do(t, srv, "POST", "/content", strings.NewReader(`{"type":"page","title":"Home","space":{"key":"DEV"},`+
+		`"body":{"storage":{"value":"<p>Hi</p>","representation":"storage"}}}`), "application/json", &created); code != http.StatusOK {
+		t.Fatalf("create returned %d", code)
+	}
+	if code := do(t, srv, "POST", "/content", strings.NewReader(`{"type":"page","title":"Home","space":{"key":"DEV"}}`), "application/json", nil); code != http.StatusBadRequest {
+		t.Errorf("creating a page with the same title returned %d", code)
+	}
+
+	var got jsonContent
+	if code := do(t, srv, "GET", "/content/"+created.Id, nil, "", &got); code != http.StatusOK || got.Title != "Home" || got.Body.Storage.Value != "<p>Hi</p>" {
+		t.Errorf("get returned %d %+v", code, got)
+	}
+
+	update := `{"type":"page","title":"Home","version":{"number":%s,"message":"Edited","minorEdit":true},"body":{"storage":{"value":"x","representation":"wiki"}}}`
+	if code := do(t, srv, "PUT", "/content/"+created.Id, strings.NewReader(strings.Replace(update, "%s", "1", 1)), "application/json", nil); code != http.StatusConflict {
+		t.Errorf("update without incrementing version returned %d", code)
+	}
+	if code := do(t, srv, "PUT", "/content/"+created.Id, strings.NewReader(strings.Replace(update, "%s", "2", 1)), "application/json", nil); code != http.StatusOK {
+		t.Errorf("update returned %d", code)
+	}
+	page, _ := srv.Page(created.Id)
+	if page.Version != 2 || page.Body != "x" || page.Representation != "wiki" || page.VersionMessage != "Edited" || !page.MinorEdit {
+		t.Errorf("unexpected page %+v", page)
+	}
+
+	if code := do(t, srv, "GET", "/content/99999", nil, "", nil); code != http.StatusNotFound {
+		t.Errorf("get of missing page returned %d", code)
+	}
+}
+
+func TestAttachments(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	pageID := srv.AddPage("DEV", "Page", "")
+
+	var added results
+	body, contentType := multipartBody(t, "a.txt", "hello", "first")
+	if code := do(t, srv, "POST", "/content/"+pageID+"/child/attachment", body, contentType, &added); code != http.StatusOK || added.Size != 1 {
+		t.Fatalf("add returned %d %+v", code, added)
+	}
+	body, contentType = multipartBody(t, "a.txt", "again", "")
+	if code := do(t, srv, "POST", "/content/"+pageID+"/child/attachment", body, contentType, nil); code != http.StatusBadRequest {
+		t.Errorf("adding an existing file name returned %d", code)
+	}
+
+	id := srv.Attachments(pageID)[0].ID
+	body, contentType = multipartBody(t, "a.txt", "updated", "second")
+	if code := do(t, srv, "POST", "/content/"+pageID+"/child/attachment/"+id+"/data", body, contentType, nil); code != http.StatusOK {
+		t.Errorf("update returned %d", code)
+	}
+	if a := srv.Attachments(pageID); len(a) != 1 || string(a[0].Data) != "updated" || a[0].Version != 2 || a[0].Comment != "second" {
+		t.Errorf("unexpected attachments %+v", a)
+	}
+
+	var found results
+	if code := do(t, srv, "GET", "/content/"+pageID+"/child/attachment?filename=a.txt", nil, "", &found); code != http.StatusOK || found.Size != 1 {
+		t.Errorf("lookup by file name returned %d %+v", code, found)
+	}
+}
+
+func TestLabelsAndSearch(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	parent := srv.AddPage("DEV", "Parent", "")
+	child := srv.AddChildPage(parent, "Child", "")
+
+	if code := do(t, srv, "POST", "/content/"+child+"/label", strings.NewReader(`[{"prefix":"global","name":"runbook"}]`), "application/json", nil); code != http.StatusOK {
+		t.Errorf("add label returned %d", code)
+	}
+	var res results
+	if code := do(t, srv, "GET", `/content/search?cql=label="runbook"+and+ancestor=`+parent, nil, "", &res); code != http.StatusOK || res.Size != 1 {
+		t.Errorf("search returned %d %+v", code, res)
+	}
+	if code := do(t, srv, "DELETE", "/content/"+child+"/label/runbook", nil, "", nil); code != http.StatusNoContent {
+		t.Errorf("remove label returned %d", code)
+	}
+	if page, _ := srv.Page(child); len(page.Labels) != 0 {
+		t.Errorf("unexpected labels %v", page.Labels)
+	}
+}
+
+func TestPagination(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	srv.PageSize = 2
+	parent := srv.AddPage("DEV", "Parent", "")
+	for _, title := range []string{"A", "B", "C"} {
+		srv.AddChildPage(parent, title, "")
+	}
+
+	var res results
+	if code := do(t, srv, "GET", "/content/"+parent+"/child/page", nil, "", &res); code != http.StatusOK || res.Size != 2 || res.Links.Next == "" {
+		t.Fatalf("first page returned %d %+v", code, res)
+	}
+	next := strings.TrimPrefix(res.Links.Next, "/rest/api")
+	res = results{}
+	if code := do(t, srv, "GET", next, nil, "", &res); code != http.StatusOK || res.Size != 1 || res.Links.Next != "" {
+		t.Errorf("last page returned %d %+v", code, res)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	srv.Username, srv.Password = "user", "secret"
+	pageID := srv.AddPage("DEV", "Page", "")
+
+	if code := do(t, srv, "GET", "/content/"+pageID, nil, "", nil); code != http.StatusUnauthorized {
+		t.Errorf("request without credentials returned %d", code)
+	}
+}
+
+// TestConcurrentRequests checks that a slow upload doesn't block others.
+func TestConcurrentRequests(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	pageID := srv.AddPage("DEV", "Page", "")
+
+	status := func(req *http.Request, err error) int {
+		if err != nil {
+			return 0
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return 0
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	done := make(chan int)
+	go func() {
+		req, err := http.NewRequest("POST", srv.URL+"/rest/api/content/"+pageID+"/child/attachment", pr)
+		if err == nil {
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+		}
+		done <- status(req, err)
+	}()
+	fw, _ := mw.CreateFormFile("file", "slow.bin")
+	fw.Write([]byte("partial"))
+
+	got := make(chan int)
+	go func() { got <- status(http.NewRequest("GET", srv.URL+"/rest/api/content/"+pageID, nil)) }()
+	select {
+	case code := <-got:
+		if code != http.StatusOK {
+			t.Errorf("get returned %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("get was blocked by an upload in progress")
+	}
+
+	mw.Close()
+	pw.Close()
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("upload returned %d", code)
+	}
+}
@@ -1,6 +1,7 @@
 package confluence
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/url"
@@ -19,28 +20,51 @@ type Content struct {
 		} `json:"storage"`
 	} `json:"body"`
 	Version struct {
-		Number int `json:"number"`
+		Number    int    `json:"number"`
+		Message   string `json:"message,omitempty"` // Shown in page history
+		MinorEdit bool   `json:"minorEdit"`         // Don't notify watchers
 	} `json:"version"`
 	Links struct {
 		WebUI string `json:"webui"`
 	} `json:"_links"`
 }
 
+// https://docs.atlassian.com/atlassian-confluence/REST/6.5.2/#content/{id}/label
+
+type Label struct {
+	Prefix string `json:"prefix"`
+	Name   string `json:"name"`
+	Id     string `json:"id,omitempty"`
+}
+
 func (w *Wiki) contentEndpoint(contentID string) (*url.URL, error) {
 	return url.ParseRequestURI(w.endPoint.String() + "/content/" + contentID)
 }
 
+func (w *Wiki) childPageEndpoint(contentID string) (*url.URL, error) {
+	return url.ParseRequestURI(w.endPoint.String() + "/content/" + contentID + "/child/page")
+}
+
+func (w *Wiki) searchEndpoint() (*url.URL, error) {
+	return url.ParseRequestURI(w.endPoint.String() + "/content/search")
+}
+
 func (w *Wiki) labelEndpoint(contentID string) (*url.URL, error) {
 	return url.ParseRequestURI(w.endPoint.String() + "/content/" + contentID + "/label")
 }
 
 func (w *Wiki) DeleteContent(contentID string) error {
+	return w.DeleteContentContext(context.Background(), contentID)
+}
+
+// DeleteContentContext is like DeleteContent but takes a context.
+func (w *Wiki) DeleteContentContext(ctx context.Context, contentID string) error {
 	contentEndPoint, err := w.contentEndpoint(contentID)
 	if err != nil {
 		return err
 	}
 
-	req, err := http.NewRequest("DELETE", contentEndPoint.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", contentEndPoint.String(), nil)
 	if err != nil {
 		return err
 	}
@@ -53,6 +77,11 @@ func (w *Wiki) DeleteContent(contentID string) error {
 }
 
 func (w *Wiki) GetContent(contentID string, expand []string) (*Content, error) {
+	return w.GetContentContext(context.Background(), contentID, expand)
+}
+
+// GetContentContext is like GetContent but takes a context.
+func (w *Wiki) GetContentContext(ctx context.Context, contentID string, expand []string) (*Content, error) {
 	contentEndpoint, err := w.contentEndpoint(contentID)
 	if err != nil {
 		return nil, err
@@ -61,7 +90,7 @@ func (w *Wiki) GetContent(contentID string, expand []string) (*Content, error) {
 	data.Set("expand", strings.Join(expand, ","))
 	contentEndpoint.RawQuery = data.Encode()
 
-	req, err := http.NewRequest("GET", contentEndpoint.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", contentEndpoint.String(), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -80,14 +109,62 @@ func (w *Wiki) GetContent(contentID string, expand []string) (*Content, error) {
 	return &content, nil
 }
 
+// ListChildren returns an iterator over the child pages of a content.
+func (w *Wiki) ListChildren(contentID string, expand []string) *ContentIterator {
+	return w.ListChildrenContext(context.Background(), contentID, expand)
+}
+
+// ListChildrenContext is like ListChildren but takes a context.
+func (w *Wiki) ListChildrenContext(ctx context.Context, contentID string, expand []string) *ContentIterator {
+	endpoint, err := w.childPageEndpoint(contentID)
+	if err != nil {
+		return &ContentIterator{Paginator: &Paginator{err: err}}
+	}
+	if len(expand) > 0 {
+		data := url.Values{}
+		data.Set("expand", strings.Join(expand, ","))
+		endpoint.RawQuery = data.Encode()
+	}
+	return &ContentIterator{Paginator: w.paginate(ctx, endpoint)}
+}
+
+// Search returns an iterator over contents matching the CQL query, e.g.
+// `type = page and space = DEV and title = "Runbook"`.
+func (w *Wiki) Search(cql string, expand []string) *ContentIterator {
+	return w.SearchContext(context.Background(), cql, expand)
+}
+
+// SearchContext is like Search but takes a context.
+func (w *Wiki) SearchContext(ctx context.Context, cql string, expand []string) *ContentIterator {
+	endpoint, err := w.searchEndpoint()
+	if err != nil {
+		return &ContentIterator{Paginator: &Paginator{err: err}}
+	}
+	data := url.Values{}
+	data.Set("cql", cql)
+	if len(expand) > 0 {
+		data.Set("expand", strings.Join(expand, ","))
+	}
+	endpoint.RawQuery = data.Encode()
+	return &ContentIterator{Paginator: w.paginate(ctx, endpoint)}
+}
+
 func (w *Wiki) UpdateContent(content *Content) (*Content, error) {
+	return w.UpdateContentContext(context.Background(), content)
+}
+
+// UpdateContentContext is like UpdateContent but takes a context.
+func (w *Wiki) UpdateContentContext(ctx context.Context, content *Content) (*Content, error) {
 	jsonbody, err := json.Marshal(content)
 	if err != nil {
 		return nil, err
 	}
 
 	contentEndPoint, err := w.contentEndpoint(content.Id)
-	req, err := http.NewRequest("PUT", contentEndPoint.String(), strings.NewReader(string(jsonbody)))
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "PUT", contentEndPoint.String(), strings.NewReader(string(jsonbody)))
 	if err != nil {
 		return nil, err
 	}
@@ -108,13 +185,14 @@ func (w *Wiki) UpdateContent(content *Content) (*Content, error) {
 }
 
 func (w *Wiki) AddLabels(contentID string, labels []string) error {
-	type Label struct {
-		Prefix string `json:"prefix"`
-		Name   string `json:"name"`
-	}
+	return w.AddLabelsContext(context.Background(), contentID, labels)
+}
+
+// AddLabelsContext is like AddLabels but takes a context.
+func (w *Wiki) AddLabelsContext(ctx context.Context, contentID string, labels []string) error {
 	var labelsContent []Label
 	for _, l := range labels {
-		labelsContent = append(labelsContent, Label{"global", l})
+		labelsContent = append(labelsContent, Label{Prefix: "global", Name: l})
 	}
 
 	jsonbody, err := json.Marshal(labelsContent)
@@ -123,7 +201,13 @@ func (w *Wiki) AddLabels(contentID string, labels []string) error {
 	}
 
 	labelEndpoint, err := w.labelEndpoint(contentID)
-	req, err := http.NewRequest("POST", labelEndpoint.String(), strings.NewReader(string(jsonbody)))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", labelEndpoint.String(), strings.NewReader(string(jsonbody)))
+	if err != nil {
+		return err
+	}
 	req.Header.Add("Content-Type", "application/json")
 
 	_, err = w.sendRequest(req)
@@ -132,3 +216,54 @@ func (w *Wiki) AddLabels(contentID string, labels []string) error {
 	}
 	return nil
 }
+
+// GetLabels returns all labels of a content.
+func (w *Wiki) GetLabels(contentID string) ([]Label, error) {
+	return w.GetLabelsContext(context.Background(), contentID)
+}
+
+// GetLabelsContext is like GetLabels but takes a context.
+func (w *Wiki) GetLabelsContext(ctx context.Context, contentID string) ([]Label, error) {
+	labelEndpoint, err := w.labelEndpoint(contentID)
+	if err != nil {
+		return nil, err
+	}
+
+	var labels []Label
+	p := w.paginate(ctx, labelEndpoint)
+	for p.Next() {
+		var label Label
+		if err := p.Decode(&label); err != nil {
+			return nil, err
+		}
+		labels = append(labels, label)
+	}
+	return labels, p.Err()
+}
+
+// RemoveLabel removes a label from a content.
+func (w *Wiki) RemoveLabel(contentID, name string) error {
+	return w.RemoveLabelContext(context.Background(), contentID, name)
+}
+
+// RemoveLabelContext is like RemoveLabel but takes a context.
+func (w *Wiki) RemoveLabelContext(ctx context.Context, contentID, name string) error {
+	labelEndpoint, err := w.labelEndpoint(contentID)
+	if err != nil {
+		return err
+	}
+	data := url.Values{}
+	data.Set("name", name)
+	labelEndpoint.RawQuery = data.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "DELETE", labelEndpoint.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.sendRequest(req)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package confluence
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIError is returned by Wiki methods when Confluence responds with an
+// unexpected status. Use errors.As to inspect it, e.g. to detect a version
+// conflict (409) or a missing page (404).
+type APIError struct {
+	StatusCode int           // HTTP status code, e.g. 409
+	Status     string        // HTTP status line, e.g. "409 Conflict"
+	Method     string        // Method of the failed request
+	URL        string        // URL of the failed request
+	Message    string        // Message reported by Confluence, if any
+	Reason     string        // Reason reported by Confluence, if any
+	Details    []string      // Validation messages reported by Confluence, if any
+	RetryAfter time.Duration // Delay requested by the Retry-After header, if any
+	Body       []byte        // Raw response body
+}
+
+// https://docs.atlassian.com/atlassian-confluence/REST/6.5.2/#d3e41
+type apiErrorBody struct {
+	StatusCode int    `json:"statusCode"`
+	Message    string `json:"message"`
+	Reason     string `json:"reason"`
+	Data       struct {
+		Errors []struct {
+			Message struct {
+				Key         string `json:"key"`
+				Translation string `json:"translation"`
+			} `json:"message"`
+		} `json:"errors"`
+	} `json:"data"`
+}
+
+func newAPIError(req *http.Request, resp *http.Response) *APIError {
+	e := &APIError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Method:     req.Method,
+		URL:        req.URL.String(),
+	}
+	e.Body, _ = ioutil.ReadAll(resp.Body)
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		e.RetryAfter = time.Duration(seconds) * time.Second
+	}
+
+	var body apiErrorBody
+	if json.Unmarshal(e.Body, &body) == nil {
+		e.Message = body.Message
+		e.Reason = body.Reason
+		for _, d := range body.Data.Errors {
+			if d.Message.Translation != "" {
+				e.Details = append(e.Details, d.Message.Translation)
+			} else if d.Message.Key != "" {
+				e.Details = append(e.Details, d.Message.Key)
+			}
+		}
+	}
+	return e
+}
+
+func (e *APIError) Error() string {
+	var msg string
+	switch {
+	case e.Message != "":
+		msg = e.Message
+	case e.StatusCode == http.StatusUnauthorized:
+		msg = "authentication failed"
+	case e.StatusCode == http.StatusServiceUnavailable:
+		msg = "service is not available"
+	default:
+		msg = "unexpected response"
+	}
+	if len(e.Details) > 0 {
+		msg += " (" + strings.Join(e.Details, "; ") + ")"
+	}
+	return fmt.Sprintf("%s %s: %s: %s", e.Method, e.URL, e.Status, msg)
+}
+
+// IsNotFound reports whether err is an APIError with status 404.
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsConflict reports whether err is an APIError with status 409, which
+// Confluence returns when a page version was not incremented properly.
+func IsConflict(err error) bool {
+	return hasStatus(err, http.StatusConflict)
+}
+
+func hasStatus(err error, code int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == code
+}
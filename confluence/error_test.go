@@ -0,0 +1,45 @@
+package confluence
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"statusCode":409,"message":"Version must be incremented on update.","reason":"Conflict",` +
+			`"data":{"errors":[{"message":{"key":"version.conflict","translation":"Current version is 3"}}]}}`))
+	}))
+	defer ts.Close()
+
+	wiki, err := NewWiki(ts.URL, BasicAuth("user", "pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = wiki.GetContent("123", nil)
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected *APIError, got %T: %v", err, err)
+	}
+	if apiErr.StatusCode != http.StatusConflict || apiErr.Method != "GET" || apiErr.Reason != "Conflict" {
+		t.Errorf("unexpected error fields: %+v", apiErr)
+	}
+	if !strings.HasSuffix(strings.SplitN(apiErr.URL, "?", 2)[0], "/rest/api/content/123") {
+		t.Errorf("unexpected URL %q", apiErr.URL)
+	}
+	if len(apiErr.Details) != 1 || apiErr.Details[0] != "Current version is 3" {
+		t.Errorf("unexpected details %q", apiErr.Details)
+	}
+	if !IsConflict(err) || IsNotFound(err) {
+		t.Errorf("status helpers disagree with %v", err)
+	}
+	if !strings.Contains(err.Error(), "Version must be incremented") {
+		t.Errorf("message missing from %q", err.Error())
+	}
+}
@@ -0,0 +1,231 @@
+package confluence
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Option configures the HTTP client used by a Wiki. See NewWiki.
+type Option func(*clientOptions) error
+
+type clientOptions struct {
+	client      *http.Client
+	timeout     time.Duration
+	tlsConfig   *tls.Config
+	proxy       func(*http.Request) (*url.URL, error)
+	retries     int
+	backoff     time.Duration
+	concurrency int
+}
+
+func (o *clientOptions) tls() *tls.Config {
+	if o.tlsConfig == nil {
+		o.tlsConfig = &tls.Config{}
+	}
+	return o.tlsConfig
+}
+
+// WithHTTPClient makes the Wiki send requests with a copy of the given client.
+// TLS and proxy options are ignored when a client is given, but WithTimeout
+// still applies if its transport is nil or an *http.Transport.
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *clientOptions) error {
+		o.client = client
+		return nil
+	}
+}
+
+// WithTimeout limits the time spent on connecting to the server, the TLS
+// handshake, and waiting for the response headers of each request once it is
+// sent. Sending the request body and reading the response are not limited,
+// so that large attachments can be transferred over slow connections. Zero
+// means no timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *clientOptions) error {
+		o.timeout = timeout
+		return nil
+	}
+}
+
+// WithRetry retries requests failed by network errors, rate limiting or an
+// unavailable server up to the given times. Requests other than GET, HEAD and
+// DELETE are retried only if rate limited or never sent, so that an update or
+// upload is never applied twice. The delay before each retry
+// starts at backoff and doubles every time, unless the server asks for a
+// longer one with Retry-After.
+func WithRetry(retries int, backoff time.Duration) Option {
+	return func(o *clientOptions) error {
+		o.retries = retries
+		o.backoff = backoff
+		return nil
+	}
+}
+
+// WithConcurrency limits the number of files AddUpdateAttachments processes
+// concurrently. The default is 1.
+func WithConcurrency(n int) Option {
+	return func(o *clientOptions) error {
+		if n < 1 {
+			return fmt.Errorf("invalid concurrency %d", n)
+		}
+		o.concurrency = n
+		return nil
+	}
+}
+
+// WithCACertFile trusts the PEM encoded certificates in the given file in
+// addition to the system certificate pool.
+func WithCACertFile(filename string) Option {
+	return func(o *clientOptions) error {
+		pem, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in %s", filename)
+		}
+		o.tls().RootCAs = pool
+		return nil
+	}
+}
+
+// WithClientCertificate presents the given PEM encoded certificate and key
+// to the server for mutual TLS authentication.
+func WithClientCertificate(certFile, keyFile string) Option {
+	return func(o *clientOptions) error {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		o.tls().Certificates = append(o.tls().Certificates, cert)
+		return nil
+	}
+}
+
+// WithInsecureSkipVerify disables verification of the server certificate.
+// Only use it as a last resort.
+func WithInsecureSkipVerify() Option {
+	return func(o *clientOptions) error {
+		o.tls().InsecureSkipVerify = true
+		return nil
+	}
+}
+
+// WithProxy sends requests through the given proxy, except for hosts matched
+// by noProxy, a comma separated list in the format of NO_PROXY. Without this
+// option the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables apply.
+func WithProxy(proxyURL, noProxy string) Option {
+	return func(o *clientOptions) error {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return err
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid proxy URL %q", proxyURL)
+		}
+		o.proxy = func(req *http.Request) (*url.URL, error) {
+			if matchNoProxy(noProxy, req.URL.Hostname()) {
+				return nil, nil
+			}
+			return u, nil
+		}
+		return nil
+	}
+}
+
+// WithNoProxy connects directly to hosts matched by noProxy, a comma separated
+// list in the format of NO_PROXY, in addition to those in NO_PROXY, when the
+// proxy is taken from the HTTP_PROXY and HTTPS_PROXY environment variables.
+func WithNoProxy(noProxy string) Option {
+	return func(o *clientOptions) error {
+		o.proxy = func(req *http.Request) (*url.URL, error) {
+			if matchNoProxy(noProxy, req.URL.Hostname()) {
+				return nil, nil
+			}
+			return http.ProxyFromEnvironment(req)
+		}
+		return nil
+	}
+}
+
+// matchNoProxy reports whether host is excluded from proxying by noProxy.
+// Entries may be "*", host names (matching subdomains as well), IP addresses
+// or CIDR ranges.
+func matchNoProxy(noProxy, host string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if ip != nil {
+			if _, cidr, err := net.ParseCIDR(entry); err == nil && cidr.Contains(ip) {
+				return true
+			}
+			if entryIP := net.ParseIP(entry); entryIP != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		entry = strings.TrimPrefix(entry, ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *clientOptions) newClient() *http.Client {
+	if o.client != nil {
+		client := *o.client
+		if o.timeout != 0 {
+			switch t := client.Transport.(type) {
+			case nil:
+				client.Transport = o.withTimeout(http.DefaultTransport.(*http.Transport).Clone())
+			case *http.Transport:
+				client.Transport = o.withTimeout(t.Clone())
+			}
+		}
+		return &client
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if o.tlsConfig != nil {
+		transport.TLSClientConfig = o.tlsConfig
+	}
+	if o.proxy != nil {
+		transport.Proxy = o.proxy
+	}
+	return &http.Client{Transport: o.withTimeout(transport)}
+}
+
+// withTimeout applies the timeout to the stages of a request other than
+// transferring bodies.
+func (o *clientOptions) withTimeout(t *http.Transport) *http.Transport {
+	if o.timeout == 0 {
+		return t
+	}
+	dialer := &net.Dialer{Timeout: o.timeout, KeepAlive: 30 * time.Second}
+	t.DialContext = dialer.DialContext
+	t.TLSHandshakeTimeout = o.timeout
+	t.ResponseHeaderTimeout = o.timeout
+	return t
+}
@@ -0,0 +1,120 @@
+package confluence
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMatchNoProxy(t *testing.T) {
+	noProxy := "localhost, .corp.example.com,10.0.0.0/8,192.168.1.1,wiki.example.org:8443"
+	for host, want := range map[string]bool{
+		"localhost":             true,
+		"corp.example.com":      true,
+		"wiki.corp.example.com": true,
+		"example.com":           false,
+		"10.1.2.3":              true,
+		"11.1.2.3":              false,
+		"192.168.1.1":           true,
+		"wiki.example.org":      true,
+		"notwiki.example.org":   false,
+	} {
+		if got := matchNoProxy(noProxy, host); got != want {
+			t.Errorf("matchNoProxy(%q) = %v, want %v", host, got, want)
+		}
+	}
+	if !matchNoProxy("*", "anything") {
+		t.Error("* should match every host")
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	stalled := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stalled
+	}))
+	defer ts.Close()
+	defer close(stalled)
+
+	wiki, err := NewWiki(ts.URL, BasicAuth("user", "pass"), WithTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := wiki.GetContent("1", nil); err == nil {
+		t.Error("request to stalled server succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("request took %v despite timeout", elapsed)
+	}
+}
+
+// slowReader returns one byte of n at a time after a delay.
+type slowReader struct {
+	n     int
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(r.delay)
+	r.n--
+	p[0] = 'x'
+	return 1, nil
+}
+
+func TestWithTimeoutSlowUpload(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+	}))
+	defer ts.Close()
+
+	o := clientOptions{timeout: 50 * time.Millisecond}
+	resp, err := o.newClient().Post(ts.URL, "text/plain", &slowReader{n: 5, delay: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("upload longer than the timeout failed: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestWithNoProxy(t *testing.T) {
+	wiki, err := NewWiki("https://wiki.corp.example.com", BasicAuth("user", "pass"), WithNoProxy(".corp.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "https://wiki.corp.example.com/rest/api/content", nil)
+	if u, err := wiki.client.Transport.(*http.Transport).Proxy(req); u != nil || err != nil {
+		t.Errorf("proxy = %v, %v, want direct connection", u, err)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	readErr := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+	for _, tc := range []struct {
+		method string
+		err    error
+		want   bool
+	}{
+		{"GET", &APIError{StatusCode: http.StatusServiceUnavailable}, true},
+		{"POST", &APIError{StatusCode: http.StatusServiceUnavailable}, false},
+		{"PUT", &APIError{StatusCode: http.StatusTooManyRequests}, true},
+		{"GET", &APIError{StatusCode: http.StatusInternalServerError}, false},
+		{"GET", readErr, true},
+		{"POST", readErr, false},
+		{"POST", dialErr, true},
+		{"PUT", &net.DNSError{Err: "no such host", Name: "wiki"}, true},
+	} {
+		req := httptest.NewRequest(tc.method, "http://wiki/rest/api/content", nil)
+		if got := isRetryable(req, tc.err); got != tc.want {
+			t.Errorf("isRetryable(%s, %v) = %v, want %v", tc.method, tc.err, got, tc.want)
+		}
+	}
+}
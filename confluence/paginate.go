@@ -0,0 +1,156 @@
+package confluence
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// https://docs.atlassian.com/atlassian-confluence/REST/6.5.2/#pagination
+
+type resultPage struct {
+	Results []json.RawMessage `json:"results"`
+	Start   int               `json:"start"`
+	Limit   int               `json:"limit"`
+	Size    int               `json:"size"`
+	Links   struct {
+		Base    string `json:"base"`
+		Context string `json:"context"`
+		Next    string `json:"next"`
+	} `json:"_links"`
+}
+
+// Paginator iterates over the results of a listing endpoint, fetching
+// further pages by following _links.next as needed. Use it like
+// bufio.Scanner:
+//
+//	for p.Next() {
+//		var v T
+//		err := p.Decode(&v)
+//		...
+//	}
+//	err := p.Err()
+type Paginator struct {
+	w       *Wiki
+	ctx     context.Context
+	next    string
+	results []json.RawMessage
+	current json.RawMessage
+	err     error
+}
+
+func (w *Wiki) paginate(ctx context.Context, endpoint *url.URL) *Paginator {
+	return &Paginator{w: w, ctx: ctx, next: endpoint.String()}
+}
+
+// Next advances to the next result, fetching the next page if necessary. It
+// returns false when there are no more results or an error occurred.
+func (p *Paginator) Next() bool {
+	for len(p.results) == 0 {
+		if p.err != nil || p.next == "" {
+			return false
+		}
+		p.fetch()
+	}
+	p.current, p.results = p.results[0], p.results[1:]
+	return true
+}
+
+func (p *Paginator) fetch() {
+	req, err := http.NewRequestWithContext(p.ctx, "GET", p.next, nil)
+	if err != nil {
+		p.err = err
+		return
+	}
+	res, err := p.w.sendRequest(req)
+	if err != nil {
+		p.err = err
+		return
+	}
+
+	var page resultPage
+	if err := json.Unmarshal(res, &page); err != nil {
+		p.err = err
+		return
+	}
+	p.results = page.Results
+	p.next = ""
+	if page.Links.Next != "" {
+		p.next, p.err = p.w.resolveNext(page.Links.Base, page.Links.Next)
+	}
+}
+
+// resolveNext returns the absolute URL of a _links.next path. The path is
+// relative to the base URL of Confluence, which may have a context path such
+// as "/wiki".
+func (w *Wiki) resolveNext(base, next string) (string, error) {
+	if base == "" {
+		u := *w.endPoint
+		u.Path = strings.TrimSuffix(u.Path, "/rest/api")
+		u.RawQuery = ""
+		base = u.String()
+	}
+	u, err := url.Parse(strings.TrimSuffix(base, "/") + next)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// Decode unmarshals the current result into v.
+func (p *Paginator) Decode(v interface{}) error {
+	return json.Unmarshal(p.current, v)
+}
+
+// Err returns the first error encountered by the Paginator.
+func (p *Paginator) Err() error {
+	return p.err
+}
+
+// AttachmentIterator iterates over attachments. See Paginator.
+type AttachmentIterator struct {
+	*Paginator
+	attachment *Attachment
+}
+
+// Next advances to the next attachment.
+func (it *AttachmentIterator) Next() bool {
+	if !it.Paginator.Next() {
+		return false
+	}
+	it.attachment = new(Attachment)
+	if it.err = it.Decode(it.attachment); it.err != nil {
+		return false
+	}
+	return true
+}
+
+// Attachment returns the current attachment.
+func (it *AttachmentIterator) Attachment() *Attachment {
+	return it.attachment
+}
+
+// ContentIterator iterates over contents. See Paginator.
+type ContentIterator struct {
+	*Paginator
+	content *Content
+}
+
+// Next advances to the next content.
+func (it *ContentIterator) Next() bool {
+	if !it.Paginator.Next() {
+		return false
+	}
+	it.content = new(Content)
+	if it.err = it.Decode(it.content); it.err != nil {
+		return false
+	}
+	return true
+}
+
+// Content returns the current content.
+func (it *ContentIterator) Content() *Content {
+	return it.content
+}
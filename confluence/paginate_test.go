@@ -0,0 +1,63 @@
+package confluence_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/p47t/md2cfl/confluence"
+	"github.com/p47t/md2cfl/confluence/confluencetest"
+)
+
+func TestPagination(t *testing.T) {
+	srv := confluencetest.NewServer()
+	defer srv.Close()
+	srv.PageSize = 2
+
+	parent := srv.AddPage("DEV", "Parent", "")
+	var want []string
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("file%d.txt", i)
+		srv.AddAttachment(parent, name, "", []byte(name))
+		srv.AddChildPage(parent, fmt.Sprintf("Child %d", i), "")
+		want = append(want, name)
+	}
+
+	// Context path must be kept when following _links.next
+	wiki, err := confluence.NewWiki(srv.URL+"/wiki", confluence.BasicAuth("user", "pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	it := wiki.ListAttachments(parent)
+	for it.Next() {
+		got = append(got, it.Attachment().Title)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got attachments %q, want %q", got, want)
+	}
+
+	children := 0
+	for it := wiki.ListChildren(parent, nil); it.Next(); {
+		children++
+	}
+	if children != 5 {
+		t.Errorf("got %d children, want 5", children)
+	}
+
+	var titles []string
+	search := wiki.Search(`type = page and title = "Child 3"`, nil)
+	for search.Next() {
+		titles = append(titles, search.Content().Title)
+	}
+	if err := search.Err(); err != nil || len(titles) != 1 {
+		t.Errorf("unexpected search result %q, %v", titles, err)
+	}
+
+	if it := wiki.ListAttachments("404"); it.Next() || !confluence.IsNotFound(it.Err()) {
+		t.Errorf("expected not found, got %v", it.Err())
+	}
+}
@@ -1,20 +1,34 @@
 package confluence
 
 import (
-	"fmt"
+	"errors"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
 type Wiki struct {
-	endPoint   *url.URL
-	authMethod AuthMethod
-	client     *http.Client
+	endPoint    *url.URL
+	authMethod  AuthMethod
+	client      *http.Client
+	retries     int
+	backoff     time.Duration
+	concurrency int
 }
 
-func NewWiki(location string, authMethod AuthMethod) (*Wiki, error) {
+// NewWiki returns a client of the Confluence REST API at location. Options
+// configure timeouts, retries, concurrency, TLS and proxy settings.
+func NewWiki(location string, authMethod AuthMethod, opts ...Option) (*Wiki, error) {
+	var o clientOptions
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return nil, err
+		}
+	}
+
 	u, err := url.ParseRequestURI(location)
 	if err != nil {
 		return nil, err
@@ -30,7 +44,10 @@ func NewWiki(location string, authMethod AuthMethod) (*Wiki, error) {
 	wiki.endPoint = u
 	wiki.authMethod = authMethod
 
-	wiki.client = &http.Client{}
+	wiki.client = o.newClient()
+	wiki.retries = o.retries
+	wiki.backoff = o.backoff
+	wiki.concurrency = o.concurrency
 
 	return wiki, nil
 }
@@ -70,32 +87,99 @@ func TokenAuth(tokenkey string) AuthMethod {
 }
 
 func (w *Wiki) sendRequest(req *http.Request) ([]byte, error) {
-	req.Header.Add("Accept", "application/json, */*")
-	req.Header.Add("X-Atlassian-Token", "no-check")
+	req.Header.Set("Accept", "application/json, */*")
+	req.Header.Set("X-Atlassian-Token", "no-check")
 	w.authMethod.auth(req)
 
+	for attempt := 0; ; attempt++ {
+		res, err := w.doRequest(req)
+		if err == nil || attempt >= w.retries || !isRetryable(req, err) {
+			return res, err
+		}
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return res, err // body can't be sent again
+			}
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+
+		delay := w.backoff << uint(attempt)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > delay {
+			delay = apiErr.RetryAfter
+		}
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+func (w *Wiki) doRequest(req *http.Request) ([]byte, error) {
 	resp, err := w.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
 	switch resp.StatusCode {
 	case http.StatusOK, http.StatusCreated, http.StatusPartialContent:
 		res, err := ioutil.ReadAll(resp.Body)
-		_ = resp.Body.Close()
 		if err != nil {
 			return nil, err
 		}
 		return res, nil
 	case http.StatusNoContent, http.StatusResetContent:
 		return nil, nil
-	case http.StatusUnauthorized:
-		return nil, fmt.Errorf("Authentication failed.")
-	case http.StatusServiceUnavailable:
-		return nil, fmt.Errorf("Service is not available (%s).", resp.Status)
-	case http.StatusInternalServerError:
-		return nil, fmt.Errorf("Internal server error: %s", resp.Status)
 	}
 
-	return nil, fmt.Errorf("Unknown response status %s", resp.Status)
+	return nil, newAPIError(req, resp)
+}
+
+// isRetryable reports whether a request failed for a transient reason and
+// may be sent again: rate limiting, which rejects requests before processing
+// them, or an unavailable server or a network error for idempotent requests.
+// Other requests are only retried if they provably never reached the server,
+// as replaying an update or upload may apply it twice.
+func isRetryable(req *http.Request, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests:
+			return true
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return isIdempotent(req.Method)
+		}
+		return false
+	}
+	if isIdempotent(req.Method) {
+		var netErr net.Error
+		return errors.As(err, &netErr)
+	}
+	return notSent(err)
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "DELETE", "OPTIONS":
+		return true
+	}
+	return false
+}
+
+// notSent reports whether err occurred before the request was sent, i.e. in
+// resolving or connecting to the server or proxy.
+func notSent(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && (opErr.Op == "dial" || opErr.Op == "proxyconnect")
 }